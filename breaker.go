@@ -0,0 +1,124 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"fmt"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+const breakerMinCommands = 10 // commands in the window before the error rate is checked
+
+var abortPattern *regexp.Regexp // compiled -abort-on, nil counts only errors
+
+var pausedUntil int64 // unix nanoseconds, clients wait before the next command until then
+var paused int32      // set while paused by the control API
+
+// breaker trips when the share of errors over the sliding window, or the number of
+// consecutive errors, exceeds the limit. This is not safe for concurrent use!
+type breaker struct {
+	maxRate        float64
+	window         time.Duration
+	maxConsecutive int
+	buckets        []errBucket // counts of each second in the window, oldest first
+	consecutive    int
+}
+
+type errBucket struct {
+	second int64
+	count  int
+	errs   int
+}
+
+// newBreaker returns the breaker configured by the flags, nil if none
+func newBreaker() *breaker {
+	if *abortErrors <= 0 && *abortConsecutive <= 0 {
+		return nil
+	}
+	return &breaker{maxRate: *abortErrors, window: *abortWindow, maxConsecutive: *abortConsecutive}
+}
+
+// add counts the command and returns the reason if the breaker trips
+func (b *breaker) add(now time.Time, failed bool) string {
+	second := now.Unix()
+	if n := len(b.buckets); n == 0 || b.buckets[n-1].second != second {
+		b.buckets = append(b.buckets, errBucket{second: second})
+	}
+	start := now.Add(-b.window).Unix()
+	for len(b.buckets) > 1 && b.buckets[0].second <= start {
+		b.buckets = b.buckets[1:]
+	}
+	bucket := &b.buckets[len(b.buckets)-1]
+	bucket.count++
+	if !failed {
+		b.consecutive = 0
+		return ""
+	}
+	bucket.errs++
+	b.consecutive++
+	if b.maxConsecutive > 0 && b.consecutive >= b.maxConsecutive {
+		return fmt.Sprintf("%d consecutive errors", b.consecutive)
+	}
+	if b.maxRate > 0 {
+		var count, errs int
+		for _, bucket := range b.buckets {
+			count += bucket.count
+			errs += bucket.errs
+		}
+		rate := float64(errs) / float64(count)
+		if count >= breakerMinCommands && rate > b.maxRate {
+			return fmt.Sprintf("error rate %0.1f%% over %s", rate*100, b.window)
+		}
+	}
+	return ""
+}
+
+func (b *breaker) reset() {
+	b.buckets = b.buckets[:0]
+	b.consecutive = 0
+}
+
+// checkBreaker counts the result and aborts the run, or pauses it with -abort-pause,
+// if the breaker trips. Results of commands in flight during a pause are not counted.
+func (results *Results) checkBreaker(res *ClientResult) {
+	now := time.Now()
+	if now.UnixNano() < atomic.LoadInt64(&pausedUntil) {
+		return
+	}
+	failed := res.Err != nil || abortPattern != nil && abortPattern.MatchString(res.Res)
+	reason := results.breaker.add(now, failed)
+	if reason == "" {
+		return
+	}
+	if *abortPause > 0 {
+		fmt.Printf("%s %s, pausing for %s   \n", now.Format("15:04:05"), reason, *abortPause)
+		results.Pauses = append(results.Pauses, fmt.Sprintf("%s for %s after %s", now.Format("15:04:05"), *abortPause, reason))
+		atomic.StoreInt64(&pausedUntil, now.Add(*abortPause).UnixNano())
+		results.breaker.reset()
+		return
+	}
+	fmt.Printf("%s, aborting...   \n", reason)
+	results.Aborted = reason
+	results.breaker = nil
+	stop = true
+}
+
+// waitPause waits until the pause ends or the run is stopped
+func waitPause() {
+	for !stop {
+		d := time.Until(time.Unix(0, atomic.LoadInt64(&pausedUntil)))
+		if d <= 0 {
+			if atomic.LoadInt32(&paused) == 0 {
+				return
+			}
+			d = 100 * time.Millisecond
+		}
+		if d > 100*time.Millisecond {
+			d = 100 * time.Millisecond // check stop now and then
+		}
+		time.Sleep(d)
+	}
+}
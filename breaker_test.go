@@ -0,0 +1,85 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreakerRate(t *testing.T) {
+	b := breaker{maxRate: 0.5, window: 10 * time.Second}
+	now := time.Unix(1000, 0)
+	for i := 0; i < 20; i++ {
+		if reason := b.add(now, i%2 == 1); reason != "" {
+			t.Fatalf("tripped at 50%%: %s", reason)
+		}
+	}
+	// the errors of the past seconds slide out of the window
+	now = now.Add(9 * time.Second)
+	for i := 0; i < 10; i++ {
+		b.add(now, false)
+	}
+	now = now.Add(time.Second)
+	for i := 0; i < 20; i++ {
+		if reason := b.add(now, true); reason != "" {
+			if i < 10 {
+				t.Errorf("tripped after %d errors: %s", i+1, reason)
+			}
+			return
+		}
+	}
+	t.Error("did not trip")
+}
+
+func TestBreakerConsecutive(t *testing.T) {
+	b := breaker{maxConsecutive: 3}
+	now := time.Now()
+	b.add(now, true)
+	b.add(now, true)
+	b.add(now, false)
+	b.add(now, true)
+	b.add(now, true)
+	if reason := b.add(now, true); reason != "3 consecutive errors" {
+		t.Errorf("reason = '%s'", reason)
+	}
+}
+
+func TestBreakerPause(t *testing.T) {
+	*abortPause = time.Minute
+	defer func() {
+		*abortPause = 0
+		atomic.StoreInt64(&pausedUntil, 0)
+	}()
+	results := NewResults(false, 0)
+	results.breaker = &breaker{maxConsecutive: 2}
+	for i := 0; i < 4; i++ {
+		results.checkBreaker(&ClientResult{Err: errors.New("connection refused")})
+	}
+	if stop || results.Aborted != "" || len(results.Pauses) != 1 ||
+		!strings.HasSuffix(results.Pauses[0], "for 1m0s after 2 consecutive errors") {
+		t.Errorf("pauses = %v, aborted '%s'", results.Pauses, results.Aborted)
+	}
+	if atomic.LoadInt64(&pausedUntil) < time.Now().Add(50*time.Second).UnixNano() {
+		t.Error("not paused")
+	}
+}
+
+func TestBreakerExtras(t *testing.T) {
+	defer func() { stop = false }()
+	results := NewResults(false, 0)
+	results.breaker = &breaker{maxConsecutive: 3}
+	failed := &ClientResult{Err: errors.New("connection refused")}
+	results.collect(&ClientResult{Res: "OK", Extra: []*ClientResult{failed, failed}})
+	if results.Aborted != "" {
+		t.Fatalf("aborted after a success: '%s'", results.Aborted)
+	}
+	results.collect(&ClientResult{Err: failed.Err, Extra: []*ClientResult{failed, failed}})
+	if !stop || results.Aborted != "3 consecutive errors" || results.Count != 6 {
+		t.Errorf("aborted '%s' after %d commands", results.Aborted, results.Count)
+	}
+}
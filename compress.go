@@ -0,0 +1,93 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstd decoders are expensive to create, reuse them between the clients
+var zstdDecoders = sync.Pool{
+	New: func() interface{} {
+		d, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		return d
+	},
+}
+
+// ValidEncoding checks that the content encoding is supported by Encode and DecodedLength
+func ValidEncoding(encoding string) error {
+	switch encoding {
+	case "", "identity", "gzip", "br", "zstd":
+		return nil
+	default:
+		return fmt.Errorf("Unsupported encoding %s, use gzip, br or zstd", encoding)
+	}
+}
+
+// Encode compresses the data with the given content encoding, gzip, br or zstd
+func Encode(encoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "", "identity":
+		return data, nil
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "zstd":
+		zw, err := zstd.NewWriter(&buf, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		w = zw
+	default:
+		return nil, ValidEncoding(encoding)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodedLength reads the body and returns the length after decompressing it with the
+// given content encoding. Both HTTP clients use this to do the same work for responses.
+func DecodedLength(encoding string, body io.Reader) (int64, error) {
+	var r io.Reader
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		r = body
+	case "gzip", "x-gzip":
+		gr, err := gzip.NewReader(body)
+		if err != nil {
+			return 0, err
+		}
+		defer gr.Close()
+		r = gr
+	case "br":
+		r = brotli.NewReader(body)
+	case "zstd":
+		d := zstdDecoders.Get().(*zstd.Decoder)
+		defer zstdDecoders.Put(d)
+		if err := d.Reset(body); err != nil {
+			return 0, err
+		}
+		r = d
+	default:
+		return 0, fmt.Errorf("Unsupported content encoding %s", encoding)
+	}
+	return io.Copy(ioutil.Discard, r)
+}
@@ -0,0 +1,36 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecodedLength(t *testing.T) {
+	data := []byte(strings.Repeat("gompet compression test ", 100))
+	for _, encoding := range []string{"", "gzip", "br", "zstd"} {
+		encoded, err := Encode(encoding, data)
+		if err != nil {
+			t.Fatalf("Encode(%s) failed: %v", encoding, err)
+		}
+		if encoding != "" && len(encoded) >= len(data) {
+			t.Errorf("Encode(%s) did not compress, %d bytes", encoding, len(encoded))
+		}
+		n, err := DecodedLength(encoding, bytes.NewReader(encoded))
+		if err != nil || n != int64(len(data)) {
+			t.Errorf("DecodedLength(%s) = %d, %v", encoding, n, err)
+		}
+	}
+}
+
+func TestUnsupportedEncoding(t *testing.T) {
+	if ValidEncoding("deflate") == nil {
+		t.Error("ValidEncoding accepted deflate")
+	}
+	if _, err := DecodedLength("compress", strings.NewReader("x")); err == nil {
+		t.Error("DecodedLength accepted compress")
+	}
+}
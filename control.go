@@ -0,0 +1,155 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ControlStatus is the state of the run returned by the control API
+type ControlStatus struct {
+	Elapsed    float64          `json:"elapsed"` // seconds since start
+	Count      int64            `json:"count"`
+	Rate       float64          `json:"rate"` // cmds/sec since start
+	Paused     bool             `json:"paused"`
+	Clients    int              `json:"clients"`
+	ClientRate int              `json:"client_rate"` // rate limit of each client, 0 for none
+	Results    map[string]int64 `json:"results"`
+	Errors     map[string]int64 `json:"errors"`
+	Recent     *RecentStats     `json:"recent,omitempty"`
+	Stopping   bool             `json:"stopping"`
+}
+
+// RecentStats are the stats since the previous search step, or since start
+type RecentStats struct {
+	Elapsed     float64   `json:"elapsed"`
+	Count       int64     `json:"count"`
+	Errors      int64     `json:"errors"`
+	Rate        float64   `json:"rate"`
+	Percentiles []float64 `json:"percentiles"`
+	Times       []float64 `json:"times"` // latencies in seconds at the percentiles
+}
+
+// registerControl adds the control API handlers to the web server
+func registerControl(mux *http.ServeMux) {
+	mux.HandleFunc("/control", controlHandler(false, nil))
+	mux.HandleFunc("/control/snapshot", controlHandler(false, nil))
+	mux.HandleFunc("/control/pause", controlHandler(true, func(r *http.Request) error {
+		atomic.StoreInt32(&paused, 1)
+		fmt.Printf("%s paused by control API   \n", time.Now().Format("15:04:05"))
+		return nil
+	}))
+	mux.HandleFunc("/control/resume", controlHandler(true, func(r *http.Request) error {
+		atomic.StoreInt32(&paused, 0)
+		atomic.StoreInt64(&pausedUntil, 0)
+		fmt.Printf("%s resumed by control API   \n", time.Now().Format("15:04:05"))
+		return nil
+	}))
+	mux.HandleFunc("/control/rate", controlHandler(true, func(r *http.Request) error {
+		rate, err := queryInt(r, 0)
+		if err == nil {
+			setClientRate(rate)
+			fmt.Printf("%s rate limit %d by control API   \n", time.Now().Format("15:04:05"), rate)
+		}
+		return err
+	}))
+	mux.HandleFunc("/control/clients", controlHandler(true, func(r *http.Request) error {
+		n, err := queryInt(r, 1)
+		if err == nil {
+			err = SetClients(n)
+		}
+		if err == nil {
+			fmt.Printf("%s %d clients by control API   \n", time.Now().Format("15:04:05"), n)
+		}
+		return err
+	}))
+	mux.HandleFunc("/control/stop", controlHandler(true, func(r *http.Request) error {
+		fmt.Println("Stopped by control API, stopping...   ")
+		stop = true
+		return nil
+	}))
+}
+
+// controlHandler runs the action, if any, and responds with the status. Actions require POST.
+func controlHandler(post bool, action func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if post && r.Method != http.MethodPost {
+			http.Error(w, "Use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if action != nil {
+			if err := action(r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		status := getStatus(r.URL.Path == "/control/snapshot")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+// queryInt returns the value parameter of the request
+func queryInt(r *http.Request, min int) (int, error) {
+	v, err := strconv.Atoi(r.URL.Query().Get("value"))
+	if err != nil || v < min {
+		return 0, fmt.Errorf("Invalid value '%s', must be at least %d", r.URL.Query().Get("value"), min)
+	}
+	return v, nil
+}
+
+// setClientRate sets the rate limit of each client, 0 removes the limit
+func setClientRate(rate int) {
+	var interval int64
+	if rate > 0 {
+		interval = int64(time.Second) / int64(rate)
+	}
+	atomic.StoreInt64(&clientInterval, interval)
+}
+
+// getStatus returns the status of the run, with the recent latencies if requested
+func getStatus(recent bool) *ControlStatus {
+	status := ControlStatus{
+		Paused:   atomic.LoadInt32(&paused) != 0,
+		Stopping: stop,
+		Results:  make(map[string]int64),
+		Errors:   make(map[string]int64),
+	}
+	if interval := atomic.LoadInt64(&clientInterval); interval > 0 {
+		status.ClientRate = int(time.Second / time.Duration(interval))
+	}
+	launchMutex.Lock()
+	status.Clients = len(pool)
+	launchMutex.Unlock()
+	inCollector(func(results *Results) {
+		status.Elapsed = time.Since(results.Start).Seconds()
+		status.Count = results.Count
+		status.Rate = float64(results.Count) / status.Elapsed
+		for k, v := range results.Results {
+			status.Results[k] = v
+		}
+		for k, v := range results.Errs {
+			status.Errors[k] = v
+		}
+		if recent {
+			snap := results.peek()
+			status.Recent = &RecentStats{Elapsed: snap.Elapsed, Count: snap.Count, Errors: snap.Errs, Rate: snap.Rate()}
+			for _, p := range percentiles {
+				t := snap.Latency.Percentile(p)
+				if math.IsNaN(t) {
+					t = 0 // JSON has no NaN
+				}
+				status.Recent.Percentiles = append(status.Recent.Percentiles, p)
+				status.Recent.Times = append(status.Recent.Times, t)
+			}
+		}
+	})
+	return &status
+}
@@ -0,0 +1,67 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestControlHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	registerControl(mux)
+	factory = func(config ClientConfig) (Client, error) { return nil, errors.New("No clients in test") }
+	defer func() {
+		atomic.StoreInt32(&paused, 0)
+		setClientRate(0)
+		factory = nil
+	}()
+	results := NewResults(false, 0)
+	results.Update(&ClientResult{Res: "200 OK", Time: 0.001})
+	quit := make(chan bool)
+	defer close(quit)
+	go func() { // the result collector
+		for {
+			select {
+			case f := <-collectorChan:
+				f(results)
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	tests := []struct {
+		method, url string
+		status      int
+	}{
+		{"GET", "/control/pause", http.StatusMethodNotAllowed},
+		{"POST", "/control/pause", http.StatusOK},
+		{"POST", "/control/rate?value=x", http.StatusBadRequest},
+		{"POST", "/control/rate?value=250", http.StatusOK},
+		{"POST", "/control/clients?value=2", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(test.method, test.url, nil))
+		if rec.Code != test.status {
+			t.Errorf("%s %s = %d, want %d", test.method, test.url, rec.Code, test.status)
+		}
+	}
+	var status ControlStatus
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/control/snapshot", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil || !status.Paused || status.ClientRate != 250 ||
+		status.Results["200 OK"] != 1 || status.Recent == nil || status.Recent.Count != 1 {
+		t.Errorf("status = %+v, %v", status, err)
+	}
+	if interval := atomic.LoadInt64(&clientInterval); interval != int64(4*time.Millisecond) {
+		t.Errorf("clientInterval = %d", interval)
+	}
+}
@@ -0,0 +1,205 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const dashboardHistory = 60 // refreshes shown in the sparklines
+const dashboardCounts = 8   // result and error counts shown
+const clearScreen = "\033[H\033[2J"
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+var dashboardDone = make(chan bool)
+
+// dashboard shows the throughput and latency history with the current counts once a second,
+// or prints a plain row per second if stdout is not a terminal
+type dashboard struct {
+	tty     bool
+	rates   []float64
+	errors  []float64   // errors/sec
+	times   [][]float64 // history of each percentile
+	rows    int         // plain rows printed
+	elapsed float64
+	clients int
+	target  float64 // total rate limit, 0 for none
+	state   string
+	results []string // top result counts
+	errs    []string // top error counts
+}
+
+// isTerminal tells if the file is a character device, i.e. not redirected
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// runDashboard refreshes the dashboard until the results have been collected
+func runDashboard() {
+	defer close(dashboardDone)
+	d := dashboard{tty: isTerminal(os.Stdout), times: make([][]float64, len(percentiles))}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+		if !inCollector(d.collect) {
+			return
+		}
+		d.status()
+		if d.tty {
+			fmt.Print(clearScreen + d.screen())
+		} else {
+			fmt.Println(d.row())
+		}
+	}
+}
+
+// collect updates the history and counts, called in the result collector
+func (d *dashboard) collect(results *Results) {
+	live := results.live.take()
+	d.elapsed = time.Since(results.Start).Seconds()
+	d.rates = appendHistory(d.rates, live.Rate())
+	d.errors = appendHistory(d.errors, float64(live.Errs)/live.Elapsed)
+	for i, p := range percentiles {
+		t := live.Latency.Percentile(p)
+		if math.IsNaN(t) {
+			t = 0
+		}
+		d.times[i] = appendHistory(d.times[i], t*1000)
+	}
+	d.results = topCounts(results.Results)
+	d.errs = topCounts(results.Errs)
+}
+
+// status updates the clients, target rate and state of the run
+func (d *dashboard) status() {
+	launchMutex.Lock()
+	d.clients = len(pool)
+	launchMutex.Unlock()
+	d.target = 0
+	if interval := atomic.LoadInt64(&clientInterval); interval > 0 {
+		d.target = float64(d.clients) * float64(time.Second) / float64(interval)
+	}
+	switch {
+	case stop:
+		d.state = "stopping"
+	case atomic.LoadInt32(&paused) != 0 || time.Now().UnixNano() < atomic.LoadInt64(&pausedUntil):
+		d.state = "paused"
+	default:
+		d.state = "running"
+	}
+}
+
+func appendHistory(history []float64, v float64) []float64 {
+	history = append(history, v)
+	if len(history) > dashboardHistory {
+		history = history[1:]
+	}
+	return history
+}
+
+// topCounts returns the largest counts as 'count<tab>key' lines
+func topCounts(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > dashboardCounts {
+		keys = keys[:dashboardCounts]
+	}
+	var lines []string
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("%d\t%s", m[k], k))
+	}
+	return lines
+}
+
+// sparkline draws the values scaled from zero to the max
+func sparkline(values []float64) string {
+	var max float64
+	for _, v := range values {
+		max = math.Max(max, v)
+	}
+	line := make([]rune, len(values))
+	for i, v := range values {
+		level := 0
+		if max > 0 {
+			level = int(v / max * float64(len(sparks)-1))
+		}
+		line[i] = sparks[level]
+	}
+	return string(line)
+}
+
+func last(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	return values[len(values)-1]
+}
+
+// screen returns the full dashboard
+func (d *dashboard) screen() string {
+	var b strings.Builder
+	elapsed := time.Duration(d.elapsed * float64(time.Second)).Round(time.Second)
+	fmt.Fprintf(&b, "Gompet %s, %s, %d clients\n", elapsed, d.state, d.clients)
+	target := "unlimited"
+	if d.target > 0 {
+		target = FormatDecimals(d.target)
+	}
+	fmt.Fprintf(&b, "Rate target %s, achieved %s cmds/sec\n\n", target, FormatDecimals(last(d.rates)))
+	fmt.Fprintf(&b, "%-10s %8s  %s\n", "Cmds/sec", FormatDecimals(last(d.rates)), sparkline(d.rates))
+	fmt.Fprintf(&b, "%-10s %8s  %s\n", "Errors/sec", FormatDecimals(last(d.errors)), sparkline(d.errors))
+	for i, p := range percentiles {
+		label := fmt.Sprintf("%3.0f%% ms", p)
+		fmt.Fprintf(&b, "%-10s %8s  %s\n", label, FormatDecimals(last(d.times[i])), sparkline(d.times[i]))
+	}
+	fmt.Fprintln(&b, "\nResult counts:")
+	for _, line := range d.results {
+		fmt.Fprintln(&b, line)
+	}
+	if len(d.errs) > 0 {
+		fmt.Fprintln(&b, "Error counts:")
+		for _, line := range d.errs {
+			fmt.Fprintln(&b, line)
+		}
+	}
+	return b.String()
+}
+
+// row returns a plain row of the latest second, preceded by the header on the first row
+func (d *dashboard) row() string {
+	var b strings.Builder
+	if d.rows == 0 {
+		b.WriteString("Time\tClients\tTarget\tCmds/sec\tErrors/sec")
+		for _, p := range percentiles {
+			fmt.Fprintf(&b, "\t%3.0f%%", p)
+		}
+		b.WriteString("\n")
+	}
+	d.rows++
+	fmt.Fprintf(&b, "%s\t%d\t%s\t%s\t%s", time.Now().Format("15:04:05"), d.clients,
+		FormatDecimals(d.target), FormatDecimals(last(d.rates)), FormatDecimals(last(d.errors)))
+	for i := range percentiles {
+		fmt.Fprintf(&b, "\t%s", FormatDecimals(last(d.times[i])))
+	}
+	return b.String()
+}
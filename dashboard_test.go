@@ -0,0 +1,46 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSparkline(t *testing.T) {
+	if got := sparkline([]float64{0, 1, 3.5, 7, 0}); got != "▁▂▄█▁" {
+		t.Errorf("sparkline = '%s'", got)
+	}
+	if got := sparkline([]float64{0, 0}); got != "▁▁" {
+		t.Errorf("sparkline of zeros = '%s'", got)
+	}
+}
+
+func TestTopCounts(t *testing.T) {
+	m := map[string]int64{"a": 1, "b": 5, "c": 5, "d": 2, "e": 1, "f": 1, "g": 1, "h": 1, "i": 1, "j": 1}
+	got := topCounts(m)
+	if len(got) != dashboardCounts || !reflect.DeepEqual(got[:4], []string{"5\tb", "5\tc", "2\td", "1\ta"}) {
+		t.Errorf("topCounts = %q", got)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	results := NewResults(false, 0)
+	results.Update(&ClientResult{Res: "200 OK", Time: 0.002})
+	d := dashboard{times: make([][]float64, len(percentiles))}
+	d.collect(results)
+	d.status()
+
+	screen := d.screen()
+	for _, want := range []string{"running, 0 clients", "Rate target unlimited", "1\t200 OK", " 50% ms        2.00  █"} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("screen has no '%s':\n%s", want, screen)
+		}
+	}
+	rows := strings.Split(d.row()+"\n"+d.row(), "\n")
+	if len(rows) != 3 || !strings.HasPrefix(rows[0], "Time\tClients") || !strings.HasSuffix(rows[2], "\t2.00\t2.00") {
+		t.Errorf("rows = %q", rows)
+	}
+}
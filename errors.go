@@ -0,0 +1,123 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// ErrorClassifier is implemented by clients which recognize their own error classes,
+// an empty class falls back to the framework classification
+type ErrorClassifier interface {
+	ClassifyError(err error) string
+}
+
+const maxErrSamples = 3 // distinct raw error messages kept for each class
+
+var http5xxPattern = regexp.MustCompile(`(?i)(^|status( code)?|http(/[\d.]+)?)[ :=]*5\d\d\b`)
+
+// The variable parts of unclassified errors
+var errNormalizers = []struct {
+	pattern *regexp.Regexp
+	replace string
+}{
+	{regexp.MustCompile(`\w+://\S+`), "<url>"},
+	{regexp.MustCompile(`\[[0-9a-fA-F:.]+\](:\d+)?|\d+\.\d+\.\d+\.\d+(:\d+)?`), "<addr>"},
+	{regexp.MustCompile(`\d+`), "N"},
+}
+
+// ClassifyError returns the class of common network, TLS, HTTP and SQL errors,
+// other errors are normalized by replacing URLs, addresses and numbers
+func ClassifyError(err error) string {
+	msg := err.Error()
+	lower := strings.ToLower(msg)
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr) || strings.Contains(lower, "no such host"):
+		return "DNS error"
+	case errors.Is(err, context.DeadlineExceeded) || os.IsTimeout(err) ||
+		errors.As(err, &netErr) && netErr.Timeout() ||
+		strings.Contains(lower, "timeout") || strings.Contains(lower, "deadline exceeded"):
+		return "Timeout"
+	case errors.Is(err, syscall.ECONNREFUSED) || strings.Contains(lower, "connection refused"):
+		return "Connection refused"
+	case errors.Is(err, syscall.ECONNRESET) || strings.Contains(lower, "connection reset"):
+		return "Connection reset"
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.EPIPE) ||
+		strings.HasSuffix(lower, "eof") || strings.Contains(lower, "broken pipe"):
+		return "Connection closed"
+	case strings.Contains(lower, "tls:") || strings.Contains(lower, "x509:"):
+		return "TLS error"
+	case http5xxPattern.MatchString(msg):
+		return "HTTP 5xx"
+	case strings.Contains(lower, "constraint"):
+		return "SQL constraint"
+	}
+	for _, n := range errNormalizers {
+		msg = n.pattern.ReplaceAllString(msg, n.replace)
+	}
+	return msg
+}
+
+// classifyErrors sets the error classes of the result, its extra results and first attempt
+func classifyErrors(classifier ErrorClassifier, res *ClientResult) {
+	if res.Err != nil && res.ErrClass == "" {
+		res.ErrClass = classifier.ClassifyError(res.Err)
+	}
+	for _, extra := range res.Extra {
+		classifyErrors(classifier, extra)
+	}
+	if res.First != nil {
+		classifyErrors(classifier, res.First)
+	}
+}
+
+// errorClass returns the class given by the client or the framework
+func errorClass(res *ClientResult) string {
+	if res.ErrClass != "" {
+		return res.ErrClass
+	}
+	return ClassifyError(res.Err)
+}
+
+// addErrSample keeps a few distinct raw messages of the class
+func (results *Results) addErrSample(class string, err error) {
+	msg := err.Error()
+	samples := results.ErrSamples[class]
+	if msg == class || len(samples) >= maxErrSamples {
+		return
+	}
+	for _, s := range samples {
+		if s == msg {
+			return
+		}
+	}
+	results.ErrSamples[class] = append(samples, msg)
+}
+
+// printErrors prints the error counts by class, each followed by its sample messages
+func printErrors(heading string, errs map[string]int64, samples map[string][]string) {
+	fmt.Println(heading)
+	var classes []string
+	for class := range errs {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		fmt.Printf("%d\t%s\n", errs[class], class)
+		for _, s := range samples[class] {
+			fmt.Printf("\t  %s\n", s)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, "Connection refused"},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, "Connection reset"},
+		{&net.DNSError{Err: "no such host", Name: "nosuch.example"}, "DNS error"},
+		{fmt.Errorf("Get http://x/: %w", context.DeadlineExceeded), "Timeout"},
+		{errors.New("Get \"http://127.0.0.1:1/\": net/http: request canceled (Client.Timeout exceeded)"), "Timeout"},
+		{errors.New("Get \"https://x/\": EOF"), "Connection closed"},
+		{errors.New("x509: certificate signed by unknown authority"), "TLS error"},
+		{errors.New("unexpected status code 503"), "HTTP 5xx"},
+		{errors.New("UNIQUE constraint failed: t.a"), "SQL constraint"},
+		{errors.New("Get http://host:8080/a?id=42: stopped after 10 redirects"), "Get <url> stopped after N redirects"},
+		{errors.New("dial 10.0.0.1:4200 failed with code 7"), "dial <addr> failed with code N"},
+	}
+	for _, test := range tests {
+		if got := ClassifyError(test.err); got != test.want {
+			t.Errorf("ClassifyError(%v) = '%s', want '%s'", test.err, got, test.want)
+		}
+	}
+}
+
+type constraintClassifier struct{}
+
+func (constraintClassifier) ClassifyError(err error) string {
+	if err.Error() == "duplicate" {
+		return "Duplicate key"
+	}
+	return ""
+}
+
+func TestErrorSamples(t *testing.T) {
+	results := NewResults(false, 0)
+	for port := 1; port <= 5; port++ {
+		err := fmt.Errorf("dial tcp 127.0.0.1:%d: connect: connection refused", port)
+		results.Update(&ClientResult{Err: err})
+	}
+	res := &ClientResult{Err: errors.New("duplicate"), Extra: []*ClientResult{{Err: errors.New("other")}}}
+	classifyErrors(constraintClassifier{}, res)
+	for _, extra := range res.Extra {
+		results.Update(extra)
+	}
+	results.Update(res)
+
+	want := map[string]int64{"Connection refused": 5, "Duplicate key": 1, "other": 1}
+	if !reflect.DeepEqual(results.Errs, want) {
+		t.Errorf("Errs = %v, want %v", results.Errs, want)
+	}
+	if samples := results.ErrSamples["Connection refused"]; len(samples) != maxErrSamples ||
+		samples[0] != "dial tcp 127.0.0.1:1: connect: connection refused" {
+		t.Errorf("samples = %v", samples)
+	}
+	if _, ok := results.ErrSamples["other"]; ok {
+		t.Error("sample kept for unchanged message")
+	}
+}
@@ -0,0 +1,51 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+// Simple TCP and UDP echo server for testing the TCP client
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+)
+
+var listenAddr = flag.String("a", "127.0.0.1:4202", "Address and port to listen, both TCP and UDP")
+
+func main() {
+	flag.Parse()
+	lis, err := net.Listen("tcp", *listenAddr)
+	if err != nil {
+		log.Fatalf("error in Listen: %s", err)
+	}
+	pc, err := net.ListenPacket("udp", *listenAddr)
+	if err != nil {
+		log.Fatalf("error in ListenPacket: %s", err)
+	}
+	go echoUDP(pc)
+
+	fmt.Println("Server listening at", *listenAddr)
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			log.Fatalf("error in Accept: %s", err)
+		}
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+	}
+}
+
+func echoUDP(pc net.PacketConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			log.Fatalf("error in ReadFrom: %s", err)
+		}
+		pc.WriteTo(buf[:n], addr)
+	}
+}
@@ -5,11 +5,15 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jkarjala/gompet"
@@ -19,6 +23,20 @@ import (
 // Long options for the testers, short ones used by the main library
 var httpAuth = flag.String("auth", "", "HTTP Authorization header")
 var httpContentType = flag.String("content-type", "application/json", "HTTP body content type")
+var httpRequestEncoding = flag.String("request-encoding", "", "Compress request bodies with gzip, br or zstd")
+var httpAcceptEncoding = flag.String("accept-encoding", "", "Request compressed responses, e.g. 'gzip, br, zstd'")
+
+var connKeepAlive = flag.Bool("keepalive", true, "Use HTTP keep-alive, false opens a new connection for each request")
+var connMax = flag.Int("max-conns", 0, "Max connections per host for each transport, 0 for fasthttp default 512")
+var connShared = flag.Bool("shared-transport", false, "Share one transport and connection pool by all clients")
+var connReuse = flag.Int("conn-reuse", 0, "Close connection after N requests, 0 for unlimited, 1 connects per request")
+var connIdleTimeout = flag.Duration("idle-timeout", 0, "Close idle connections after given duration, 0 for fasthttp default 10s")
+
+var tlsOptions = gompet.TLSFlags()
+
+var sharedLock sync.Mutex // guards sharedClient and sharedStats
+var sharedClient *fasthttp.Client
+var sharedStats *dialStats
 
 func main() {
 	log.Println("FastHTTP tester started")
@@ -30,18 +48,100 @@ type myClient struct {
 	req        *fasthttp.Request
 	res        *fasthttp.Response
 	httpClient *fasthttp.Client
+	stats      *dialStats
+	connReqs   int // requests sent on the current connection
+}
+
+// dialStats collects the connections opened by a fasthttp client until taken by a command.
+// With a shared client the connections are attributed to the next command which completes.
+type dialStats struct {
+	mu      sync.Mutex
+	conns   int
+	timings []gompet.Timing
+}
+
+func (s *dialStats) add(name string, start time.Time) {
+	s.mu.Lock()
+	s.timings = append(s.timings, gompet.Timing{Name: name, Time: time.Since(start).Seconds()})
+	s.mu.Unlock()
+}
+
+func (s *dialStats) take() (int, []gompet.Timing) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns, timings := s.conns, s.timings
+	s.conns, s.timings = 0, nil
+	return conns, timings
 }
 
 func clientFactory(config gompet.ClientConfig) (gompet.Client, error) {
 	log.Println(config.ID, "fasthttp init")
+	if err := gompet.ValidEncoding(*httpRequestEncoding); err != nil {
+		return nil, err
+	}
 
 	var req = fasthttp.AcquireRequest()
 	var res = fasthttp.AcquireResponse()
-	var httpClient = &fasthttp.Client{}
-	var client = myClient{config, req, res, httpClient}
+	var client = myClient{config: config, req: req, res: res}
+	if *connShared {
+		sharedLock.Lock()
+		defer sharedLock.Unlock()
+		if sharedClient != nil {
+			client.httpClient, client.stats = sharedClient, sharedStats
+			return &client, nil
+		}
+	}
+
+	tlsConfig, err := gompet.NewTLSConfig(tlsOptions)
+	if err != nil {
+		return nil, err
+	}
+	client.stats = &dialStats{}
+	client.httpClient = &fasthttp.Client{
+		TLSConfig:           tlsConfig,
+		MaxConnsPerHost:     *connMax,
+		MaxConnWaitTimeout:  10 * time.Second, // wait for a free connection with -max-conns
+		MaxIdleConnDuration: *connIdleTimeout,
+		ConfigureClient:     client.stats.configureHostClient,
+	}
+	if *connShared {
+		sharedClient, sharedStats = client.httpClient, client.stats
+	}
 	return &client, nil
 }
 
+// configureHostClient installs a dialer which counts connections and times the TLS handshake
+func (s *dialStats) configureHostClient(hc *fasthttp.HostClient) error {
+	hc.Dial = func(addr string) (net.Conn, error) {
+		start := time.Now()
+		conn, err := fasthttp.Dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+		s.add("Connect", start)
+		if !hc.IsTLS {
+			return conn, nil
+		}
+		config := hc.TLSConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tlsConn := tls.Client(conn, config)
+		start = time.Now()
+		err = tlsConn.Handshake()
+		s.add("TLS handshake", start)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+	return nil
+}
+
 func (c *myClient) RunCommand(in *gompet.ClientInput) *gompet.ClientResult {
 	cmd := in.Cmd
 	if c.config.Template != nil {
@@ -66,22 +166,47 @@ func (c *myClient) RunCommand(in *gompet.ClientInput) *gompet.ClientResult {
 	c.req.SetRequestURI(url)
 
 	if body != "" {
+		reqBody, err := gompet.Encode(*httpRequestEncoding, []byte(body))
+		if err != nil {
+			return &gompet.ClientResult{Err: err}
+		}
 		c.req.Header.Set("Content-Type", *httpContentType)
-		c.req.SetBody([]byte(body))
+		if *httpRequestEncoding != "" {
+			c.req.Header.Set("Content-Encoding", *httpRequestEncoding)
+		}
+		c.req.SetBody(reqBody)
+	}
+	if *httpAcceptEncoding != "" {
+		c.req.Header.Set("Accept-Encoding", *httpAcceptEncoding)
 	}
 	if *httpAuth != "" {
 		c.req.Header.Add("Authorization", *httpAuth)
 	}
+	// last request allowed on the connection asks the server to close it
+	closeConn := !*connKeepAlive || (*connReuse > 0 && c.connReqs+1 >= *connReuse)
+	if closeConn {
+		c.req.SetConnectionClose()
+	}
 
 	c.res.Reset()
 	var start = time.Now()
 	err = c.httpClient.Do(c.req, c.res)
 	elapsed := time.Since(start).Seconds()
+	conns, timings := c.stats.take()
+	c.countConnReqs(conns, closeConn)
 	if err != nil {
-		return &gompet.ClientResult{Err: err, Time: elapsed}
+		return &gompet.ClientResult{Err: err, Time: elapsed, Conns: conns, Timings: timings}
 	}
 	status := c.res.StatusCode()
 	resBody := c.res.Body()
+	var decoded int64
+	if *httpAcceptEncoding != "" {
+		decoded, err = gompet.DecodedLength(string(c.res.Header.Peek("Content-Encoding")), bytes.NewReader(resBody))
+		if err != nil {
+			return &gompet.ClientResult{Err: err, Time: time.Since(start).Seconds(), Conns: conns, Timings: timings,
+				ReqBytes: int64(len(c.req.Body())), RespBytes: int64(len(resBody))}
+		}
+	}
 	if status < 200 || status > 299 {
 		log.Printf("%d fasthttp status %d body '%s'", c.config.ID, status, string(resBody))
 	}
@@ -90,7 +215,20 @@ func (c *myClient) RunCommand(in *gompet.ClientInput) *gompet.ClientResult {
 	if c.config.Verbose {
 		log.Printf("%d fasthttp %s '%s' body '%s'", c.config.ID, cmd, res, string(resBody))
 	}
-	return &gompet.ClientResult{Res: res, Time: elapsed}
+	return &gompet.ClientResult{Res: res, Time: elapsed, Conns: conns, Timings: timings,
+		ReqBytes: int64(len(c.req.Body())), RespBytes: int64(len(resBody)), Decoded: decoded}
+}
+
+// countConnReqs keeps track of requests sent on the current connection for -conn-reuse
+func (c *myClient) countConnReqs(newConns int, closed bool) {
+	switch {
+	case closed:
+		c.connReqs = 0
+	case newConns > 0:
+		c.connReqs = 1
+	default:
+		c.connReqs++
+	}
 }
 
 func (c *myClient) Term() {
@@ -0,0 +1,262 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+// gRPC Client for unary methods, messages are built from JSON with protobuf descriptors
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jkarjala/gompet"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Long options for the testers, short ones used by the main library
+var grpcAddr = flag.String("addr", "", "Server address, e.g. localhost:4201")
+var grpcProtoSet = flag.String("proto-set", "", "Protobuf descriptor set file, server reflection is used if not given")
+var grpcPlaintext = flag.Bool("plaintext", false, "Use plaintext HTTP/2 instead of TLS")
+var grpcSharedConn = flag.Bool("shared-conn", false, "Share one connection by all clients, multiplexing the calls")
+var grpcAuth = flag.String("auth", "", "Authorization metadata for each call")
+var grpcTimeout = flag.Int("timeout", 10, "Call timeout in seconds")
+
+var tlsOptions = gompet.TLSFlags()
+
+func main() {
+	log.Println("gRPC tester started")
+	gompet.Run(clientFactory)
+}
+
+// method holds the resolved descriptors of one gRPC method
+type method struct {
+	path   string // e.g. /package.Service/Method
+	input  protoreflect.MessageDescriptor
+	output protoreflect.MessageDescriptor
+}
+
+// methods are resolved once and shared by all clients
+var methodsLock sync.Mutex
+var methods = make(map[string]*method)
+var files *protoregistry.Files
+
+var sharedLock sync.Mutex // guards sharedConn
+var sharedConn *grpc.ClientConn
+
+type myClient struct {
+	config gompet.ClientConfig
+	conn   *grpc.ClientConn
+}
+
+func clientFactory(config gompet.ClientConfig) (gompet.Client, error) {
+	log.Println(config.ID, "grpc init")
+	if *grpcAddr == "" {
+		return nil, errors.New("Missing --addr")
+	}
+	if *grpcProtoSet != "" {
+		methodsLock.Lock()
+		var err error
+		if files == nil {
+			files, err = loadProtoSet(*grpcProtoSet)
+		}
+		methodsLock.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := connection()
+	if err != nil {
+		return nil, err
+	}
+	var client = myClient{config, conn}
+	return &client, nil
+}
+
+// connection returns the shared connection with --shared-conn, otherwise a new one
+func connection() (*grpc.ClientConn, error) {
+	if !*grpcSharedConn {
+		return dial()
+	}
+	sharedLock.Lock()
+	defer sharedLock.Unlock()
+	if sharedConn == nil {
+		conn, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		sharedConn = conn
+	}
+	return sharedConn, nil
+}
+
+func dial() (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if !*grpcPlaintext {
+		tlsConfig, err := gompet.NewTLSConfig(tlsOptions)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	return grpc.NewClient(*grpcAddr, grpc.WithTransportCredentials(creds))
+}
+
+// loadProtoSet reads a descriptor set created with protoc --descriptor_set_out --include_imports
+func loadProtoSet(filename string) (*protoregistry.Files, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err = proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("Invalid descriptor set %s: %v", filename, err)
+	}
+	return protodesc.NewFiles(&set)
+}
+
+// resolve finds the method descriptor from the descriptor set or with server reflection
+func (c *myClient) resolve(name string) (*method, error) {
+	methodsLock.Lock()
+	defer methodsLock.Unlock()
+	if m, ok := methods[name]; ok {
+		return m, nil
+	}
+
+	i := strings.LastIndexAny(name, "/.")
+	if i < 0 {
+		return nil, fmt.Errorf("Invalid method %s, use package.Service/Method", name)
+	}
+	service := protoreflect.FullName(strings.TrimPrefix(name[:i], "/"))
+	registry := files
+	if registry == nil {
+		var err error
+		registry, err = c.reflectService(service)
+		if err != nil {
+			return nil, err
+		}
+	}
+	d, err := registry.FindDescriptorByName(service)
+	if err != nil {
+		return nil, fmt.Errorf("Service %s: %v", service, err)
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(name[i+1:]))
+	if md == nil {
+		return nil, fmt.Errorf("Method %s not found in %s", name[i+1:], service)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("Streaming method %s is not supported", name)
+	}
+	m := &method{fmt.Sprintf("/%s/%s", service, md.Name()), md.Input(), md.Output()}
+	methods[name] = m
+	return m, nil
+}
+
+// reflectService asks the server for the file descriptors of the service and its dependencies
+func (c *myClient) reflectService(service protoreflect.FullName) (*protoregistry.Files, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*grpcTimeout)*time.Second)
+	defer cancel()
+	stream, err := rpb.NewServerReflectionClient(c.conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req := &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: string(service)},
+	}
+	if err = stream.Send(req); err != nil {
+		return nil, err
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("Server reflection failed: %v", err)
+	}
+	stream.CloseSend()
+	if e := resp.GetErrorResponse(); e != nil {
+		return nil, fmt.Errorf("Server reflection for %s failed: %s", service, e.ErrorMessage)
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	for _, data := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		var file descriptorpb.FileDescriptorProto
+		if err = proto.Unmarshal(data, &file); err != nil {
+			return nil, err
+		}
+		set.File = append(set.File, &file)
+	}
+	return protodesc.NewFiles(&set)
+}
+
+func (c *myClient) RunCommand(in *gompet.ClientInput) *gompet.ClientResult {
+	cmd := in.Cmd
+	if c.config.Template != nil {
+		cmd = c.config.Template.Expand(in.Args)
+	}
+
+	var ss = strings.SplitN(strings.Trim(cmd, "\r\t "), " ", 2)
+	var body = "{}"
+	if len(ss) == 2 {
+		body = strings.Trim(ss[1], "\r\t ")
+	}
+	m, err := c.resolve(ss[0])
+	if err != nil {
+		return &gompet.ClientResult{Err: err}
+	}
+	req := dynamicpb.NewMessage(m.input)
+	if err = protojson.Unmarshal([]byte(body), req); err != nil {
+		return &gompet.ClientResult{Err: fmt.Errorf("Invalid request for %s: %v", m.path, err)}
+	}
+	resp := dynamicpb.NewMessage(m.output)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*grpcTimeout)*time.Second)
+	defer cancel()
+	if *grpcAuth != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", *grpcAuth)
+	}
+
+	var start = time.Now()
+	err = c.conn.Invoke(ctx, m.path, req, resp)
+	elapsed := time.Since(start).Seconds()
+	st, ok := status.FromError(err)
+	if !ok {
+		return &gompet.ClientResult{Err: err, Time: elapsed}
+	}
+	var res = st.Code().String()
+	if c.config.Verbose {
+		json, _ := protojson.Marshal(resp)
+		log.Printf("%d grpc %s: %s %s %s", c.config.ID, cmd, res, st.Message(), json)
+	}
+	return &gompet.ClientResult{Res: res, Time: elapsed,
+		ReqBytes: int64(proto.Size(req)), RespBytes: int64(proto.Size(resp))}
+}
+
+func (c *myClient) Term() {
+	log.Println(c.config.ID, "grpc term")
+	sharedLock.Lock()
+	shared := c.conn == sharedConn
+	sharedLock.Unlock()
+	if !shared {
+		c.conn.Close()
+	}
+}
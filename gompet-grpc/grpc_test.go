@@ -0,0 +1,160 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/jkarjala/gompet"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// compileEcho compiles testdata/echo.proto, the service of gompet-grpcserver
+func compileEcho(t *testing.T) protoreflect.FileDescriptor {
+	compiler := protocompile.Compiler{Resolver: &protocompile.SourceResolver{ImportPaths: []string{"../testdata"}}}
+	compiled, err := compiler.Compile(context.Background(), "echo.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compiled[0]
+}
+
+// startEcho starts an in-process echo server with reflection, returns its address
+func startEcho(t *testing.T, fd protoreflect.FileDescriptor) (*grpc.Server, string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := fd.Messages().ByName("EchoRequest")
+	response := fd.Messages().ByName("EchoResponse")
+	handler := func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := dynamicpb.NewMessage(request)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		message := req.Get(request.Fields().ByName("message")).String()
+		if code := codes.Code(req.Get(request.Fields().ByName("code")).Int()); code != codes.OK {
+			return nil, status.Error(code, message)
+		}
+		resp := dynamicpb.NewMessage(response)
+		resp.Set(response.Fields().ByName("message"), protoreflect.ValueOfString(message))
+		return resp, nil
+	}
+
+	var registry protoregistry.Files
+	if err = registry.RegisterFile(fd); err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "gompet.Echo",
+		HandlerType: (*interface{})(nil),
+		Methods:     []grpc.MethodDesc{{MethodName: "Echo", Handler: handler}},
+		Metadata:    fd.Path(),
+	}, struct{}{})
+	rpb.RegisterServerReflectionServer(server, reflection.NewServerV1(reflection.ServerOptions{
+		Services: server, DescriptorResolver: &registry}))
+	go server.Serve(lis)
+	return server, lis.Addr().String()
+}
+
+// newClient creates a client resolving the methods from the descriptor set file, or with
+// server reflection if the file is empty
+func newClient(t *testing.T, addr string, protoSet string) *myClient {
+	*grpcAddr = addr
+	*grpcPlaintext = true
+	*grpcProtoSet = protoSet
+	files = nil
+	methods = make(map[string]*method)
+	client, err := clientFactory(gompet.ClientConfig{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.(*myClient)
+}
+
+// runEcho runs the commands of testdata/grpc.txt
+func runEcho(t *testing.T, c *myClient) {
+	file, err := os.Open("../testdata/grpc.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	want := []string{"OK", "OK", "NotFound", "Unavailable"}
+	scanner := bufio.NewScanner(file)
+	for i := 0; scanner.Scan(); i++ {
+		res := c.RunCommand(&gompet.ClientInput{Cmd: scanner.Text()})
+		if res.Err != nil || res.Res != want[i] {
+			t.Errorf("%s: got '%s' %v, want '%s'", scanner.Text(), res.Res, res.Err, want[i])
+		}
+		if res.ReqBytes == 0 {
+			t.Errorf("%s: no request bytes", scanner.Text())
+		}
+	}
+}
+
+func TestReflection(t *testing.T) {
+	server, addr := startEcho(t, compileEcho(t))
+	defer server.Stop()
+	c := newClient(t, addr, "")
+	defer c.Term()
+
+	runEcho(t, c)
+	if res := c.RunCommand(&gompet.ClientInput{Cmd: "gompet.Echo/Missing {}"}); res.Err == nil {
+		t.Errorf("unknown method: %+v", res)
+	}
+	if res := c.RunCommand(&gompet.ClientInput{Cmd: `gompet.Echo/Echo {"unknown": 1}`}); res.Err == nil {
+		t.Errorf("invalid request: %+v", res)
+	}
+}
+
+func TestProtoSet(t *testing.T) {
+	fd := compileEcho(t)
+	server, addr := startEcho(t, fd)
+	defer server.Stop()
+
+	dir, err := ioutil.TempDir("", "gompet-grpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(fd)}}
+	data, _ := proto.Marshal(set)
+	protoSet := filepath.Join(dir, "echo.pb")
+	if err = ioutil.WriteFile(protoSet, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	*grpcSharedConn = true
+	defer func() { *grpcSharedConn = false; sharedConn = nil }()
+	c := newClient(t, addr, protoSet)
+	defer c.Term()
+	c2, err := clientFactory(gompet.ClientConfig{ID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Term()
+	if c2.(*myClient).conn != c.conn {
+		t.Error("-shared-conn clients have different connections")
+	}
+	runEcho(t, c)
+	runEcho(t, c2.(*myClient))
+}
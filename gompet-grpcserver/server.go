@@ -0,0 +1,145 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+// Simple gRPC echo server with reflection for testing the gRPC client
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var listenAddr = flag.String("a", "127.0.0.1:4201", "Address and port to listen")
+var descriptorOut = flag.String("descriptor-out", "", "Write the echo service descriptor set to file for gompet-grpc -proto-set")
+
+// echoFile describes the service in testdata/echo.proto, built here to avoid generated code
+var echoFile = &descriptorpb.FileDescriptorProto{
+	Name:    proto.String("gompet/echo.proto"),
+	Package: proto.String("gompet"),
+	Syntax:  proto.String("proto3"),
+	MessageType: []*descriptorpb.DescriptorProto{
+		{
+			Name: proto.String("EchoRequest"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("message", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("code", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+				field("delay_ms", 3, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+			},
+		},
+		{
+			Name: proto.String("EchoResponse"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("message", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			},
+		},
+	},
+	Service: []*descriptorpb.ServiceDescriptorProto{
+		{
+			Name: proto.String("Echo"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{
+					Name:       proto.String("Echo"),
+					InputType:  proto.String(".gompet.EchoRequest"),
+					OutputType: proto.String(".gompet.EchoResponse"),
+				},
+			},
+		},
+	},
+}
+
+func field(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Type:   typ.Enum(),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+}
+
+var request, response protoreflect.MessageDescriptor
+
+func main() {
+	flag.Parse()
+
+	if err := loadEcho(); err != nil {
+		log.Fatal(err)
+	}
+	if *descriptorOut != "" {
+		data, _ := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{echoFile}})
+		if err := ioutil.WriteFile(*descriptorOut, data, 0644); err != nil {
+			log.Fatalf("cannot write descriptor: %s", err)
+		}
+	}
+
+	lis, err := net.Listen("tcp", *listenAddr)
+	if err != nil {
+		log.Fatalf("error in Listen: %s", err)
+	}
+	server := newServer()
+	fmt.Println("Server listening at", *listenAddr)
+	if err := server.Serve(lis); err != nil {
+		log.Fatalf("error in Serve: %s", err)
+	}
+}
+
+// loadEcho builds the echo descriptors and registers them for reflection
+func loadEcho() error {
+	fd, err := protodesc.NewFile(echoFile, protoregistry.GlobalFiles)
+	if err != nil {
+		return fmt.Errorf("invalid echo descriptor: %s", err)
+	}
+	if err = protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		return fmt.Errorf("cannot register echo descriptor: %s", err)
+	}
+	request = fd.Messages().ByName("EchoRequest")
+	response = fd.Messages().ByName("EchoResponse")
+	return nil
+}
+
+// newServer creates the server with the echo service and reflection, call loadEcho first
+func newServer() *grpc.Server {
+	server := grpc.NewServer()
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "gompet.Echo",
+		HandlerType: (*interface{})(nil),
+		Methods:     []grpc.MethodDesc{{MethodName: "Echo", Handler: echoHandler}},
+		Metadata:    echoFile.GetName(),
+	}, struct{}{})
+	reflection.Register(server)
+	return server
+}
+
+// echoHandler returns the message back, optionally after a delay or with an error code
+func echoHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := dynamicpb.NewMessage(request)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	fields := request.Fields()
+	message := req.Get(fields.ByName("message")).String()
+	if delay := req.Get(fields.ByName("delay_ms")).Int(); delay > 0 {
+		time.Sleep(time.Duration(delay) * time.Millisecond)
+	}
+	if code := codes.Code(req.Get(fields.ByName("code")).Int()); code != codes.OK {
+		return nil, status.Error(code, message)
+	}
+	resp := dynamicpb.NewMessage(response)
+	resp.Set(response.Fields().ByName("message"), protoreflect.ValueOfString(message))
+	return resp, nil
+}
@@ -0,0 +1,87 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestMain(m *testing.M) {
+	if err := loadEcho(); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(m.Run())
+}
+
+// TestDescriptor checks that the descriptor built in code matches testdata/echo.proto
+func TestDescriptor(t *testing.T) {
+	compiler := protocompile.Compiler{Resolver: &protocompile.SourceResolver{ImportPaths: []string{"../testdata"}}}
+	compiled, err := compiler.Compile(context.Background(), "echo.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := protodesc.ToFileDescriptorProto(compiled[0])
+	want.Name = echoFile.Name
+	for _, msg := range want.MessageType {
+		for _, f := range msg.Field {
+			f.JsonName = nil // derived from the name when not set
+		}
+	}
+	if !proto.Equal(want, echoFile) {
+		t.Errorf("descriptor differs from echo.proto:\n%v\nwant\n%v", echoFile, want)
+	}
+}
+
+func TestEcho(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newServer()
+	go server.Serve(lis)
+	defer server.Stop()
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	echo := func(message string, code, delay int32) (string, error) {
+		fields := request.Fields()
+		req := dynamicpb.NewMessage(request)
+		req.Set(fields.ByName("message"), protoreflect.ValueOfString(message))
+		req.Set(fields.ByName("code"), protoreflect.ValueOfInt32(code))
+		req.Set(fields.ByName("delay_ms"), protoreflect.ValueOfInt32(delay))
+		resp := dynamicpb.NewMessage(response)
+		err := conn.Invoke(context.Background(), "/gompet.Echo/Echo", req, resp)
+		return resp.Get(response.Fields().ByName("message")).String(), err
+	}
+
+	if got, err := echo("hello", 0, 0); err != nil || got != "hello" {
+		t.Errorf("echo = '%s', %v", got, err)
+	}
+	start := time.Now()
+	if _, err := echo("slow", 0, 20); err != nil || time.Since(start) < 20*time.Millisecond {
+		t.Errorf("delay took %s, %v", time.Since(start), err)
+	}
+	_, err = echo("missing", int32(codes.NotFound), 0)
+	if st, _ := status.FromError(err); st.Code() != codes.NotFound || st.Message() != "missing" {
+		t.Errorf("error status = %v", st)
+	}
+}
@@ -5,6 +5,8 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
@@ -12,7 +14,10 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptrace"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jkarjala/gompet"
@@ -22,6 +27,23 @@ import (
 var httpAuth = flag.String("auth", "", "HTTP Authorization header")
 var httpContentType = flag.String("content-type", "application/json", "HTTP body content type")
 var httpTimeout = flag.Int("timeout", 10, "HTTP Client timeout in seconds")
+var httpPhases = flag.Bool("phases", false, "Report DNS, connect, TLS, TTFB and body time percentiles")
+var httpMaxRedirects = flag.Int("max-redirects", 10, "Follow at most N redirects, 0 returns the redirect response")
+var httpRedirectHops = flag.Bool("redirect-hops", false, "Report each redirect hop as a separate result")
+var httpCookies = flag.Bool("cookies", false, "Keep cookies in a separate cookie jar for each client")
+var httpRequestEncoding = flag.String("request-encoding", "", "Compress request bodies with gzip, br or zstd")
+var httpAcceptEncoding = flag.String("accept-encoding", "", "Request compressed responses, e.g. 'gzip, br, zstd'")
+
+var connKeepAlive = flag.Bool("keepalive", true, "Use HTTP keep-alive, false opens a new connection for each request")
+var connMax = flag.Int("max-conns", 0, "Max connections per host for each transport, 0 for unlimited")
+var connShared = flag.Bool("shared-transport", false, "Share one transport and connection pool by all clients")
+var connReuse = flag.Int("conn-reuse", 0, "Close connection after N requests, 0 for unlimited, 1 connects per request")
+var connIdleTimeout = flag.Duration("idle-timeout", 0, "Close idle connections after given duration, 0 for no limit")
+
+var sharedLock sync.Mutex // guards sharedTransport
+var sharedTransport *http.Transport
+
+var tlsOptions = gompet.TLSFlags()
 
 func main() {
 	log.Println("HTTP tester started")
@@ -31,25 +53,165 @@ func main() {
 type myClient struct {
 	config     gompet.ClientConfig
 	httpClient *http.Client
+	connReqs   int                    // requests sent on the current connection
+	hops       []*gompet.ClientResult // redirect hops of the current command
+	hopStart   time.Time              // start time of the current hop
+}
+
+// requestTrace records the connections and the phase timings of one request.
+// The dialer may call the hooks from several goroutines, e.g. when connecting to IPv4 and IPv6,
+// so the mutex guards all the fields.
+type requestTrace struct {
+	conns        int
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	wrote        time.Time
+	mutex        sync.Mutex
+	timings      []gompet.Timing
+}
+
+// mark sets the start time of a phase
+func (t *requestTrace) mark(start *time.Time) {
+	t.mutex.Lock()
+	*start = time.Now()
+	t.mutex.Unlock()
+}
+
+// add records the time of a phase since its start time
+func (t *requestTrace) add(name string, start *time.Time) {
+	t.mutex.Lock()
+	t.timings = append(t.timings, gompet.Timing{Name: name, Time: time.Since(*start).Seconds()})
+	t.mutex.Unlock()
+}
+
+// getTimings returns a copy of the timings, late hooks of abandoned dials may still add to them
+func (t *requestTrace) getTimings() []gompet.Timing {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]gompet.Timing(nil), t.timings...)
+}
+
+// getConns returns the number of new connections
+func (t *requestTrace) getConns() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.conns
+}
+
+func (t *requestTrace) clientTrace() *httptrace.ClientTrace {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if !info.Reused {
+				t.mutex.Lock()
+				t.conns++
+				t.mutex.Unlock()
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.mark(&t.tlsStart)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.add("TLS handshake", &t.tlsStart)
+		},
+	}
+	if *httpPhases {
+		trace.DNSStart = func(httptrace.DNSStartInfo) {
+			t.mark(&t.dnsStart)
+		}
+		trace.DNSDone = func(httptrace.DNSDoneInfo) {
+			t.add("DNS", &t.dnsStart)
+		}
+		trace.ConnectStart = func(network, addr string) {
+			t.mark(&t.connectStart)
+		}
+		trace.ConnectDone = func(network, addr string, err error) {
+			t.add("Connect", &t.connectStart)
+		}
+		trace.WroteRequest = func(httptrace.WroteRequestInfo) {
+			t.mark(&t.wrote)
+		}
+		trace.GotFirstResponseByte = func() {
+			t.add("TTFB", &t.wrote)
+		}
+	}
+	return trace
+}
+
+// transport returns the shared transport with --shared-transport, otherwise a new one
+func transport(config gompet.ClientConfig) (*http.Transport, error) {
+	if !*connShared {
+		return newTransport(config)
+	}
+	sharedLock.Lock()
+	defer sharedLock.Unlock()
+	if sharedTransport == nil {
+		tr, err := newTransport(config)
+		if err != nil {
+			return nil, err
+		}
+		sharedTransport = tr
+	}
+	return sharedTransport, nil
 }
 
 func clientFactory(config gompet.ClientConfig) (gompet.Client, error) {
 	log.Println(config.ID, "http init")
+	if err := gompet.ValidEncoding(*httpRequestEncoding); err != nil {
+		return nil, err
+	}
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-		MaxIdleConnsPerHost: 2, // each client has its own HTTP client
-		DisableCompression:  false,
-		DisableKeepAlives:   false,
+	tr, err := transport(config)
+	if err != nil {
+		return nil, err
 	}
-	tr.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
 	httpClient := &http.Client{Transport: tr, Timeout: time.Duration(*httpTimeout) * time.Second}
-	var client = myClient{config, httpClient}
+	var client = myClient{config: config, httpClient: httpClient}
+	httpClient.CheckRedirect = client.checkRedirect
+	if *httpCookies {
+		httpClient.Jar, _ = cookiejar.New(nil) // never fails with nil options
+	}
 	return &client, nil
 }
 
+// checkRedirect limits the number of redirects and records the hops if requested
+func (c *myClient) checkRedirect(req *http.Request, via []*http.Request) error {
+	if *httpMaxRedirects == 0 {
+		return http.ErrUseLastResponse
+	}
+	if len(via) > *httpMaxRedirects {
+		return fmt.Errorf("stopped after %d redirects", *httpMaxRedirects)
+	}
+	if *httpRedirectHops {
+		now := time.Now()
+		hop := &gompet.ClientResult{Res: req.Response.Status, Time: now.Sub(c.hopStart).Seconds()}
+		c.hops = append(c.hops, hop)
+		c.hopStart = now
+	}
+	return nil
+}
+
+func newTransport(config gompet.ClientConfig) (*http.Transport, error) {
+	tlsConfig, err := gompet.NewTLSConfig(tlsOptions)
+	if err != nil {
+		return nil, err
+	}
+	maxIdle := 2 // each client has its own HTTP client
+	if *connShared {
+		maxIdle = config.NumClients
+	}
+	tr := &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConnsPerHost: maxIdle,
+		MaxConnsPerHost:     *connMax,
+		IdleConnTimeout:     *connIdleTimeout,
+		DisableCompression:  true, // decompressed explicitly like in fasthttp client
+		DisableKeepAlives:   !*connKeepAlive,
+	}
+	tr.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	return tr, nil
+}
+
 func (c *myClient) RunCommand(in *gompet.ClientInput) *gompet.ClientResult {
 	cmd := in.Cmd
 	if c.config.Template != nil {
@@ -71,41 +233,105 @@ func (c *myClient) RunCommand(in *gompet.ClientInput) *gompet.ClientResult {
 		body = strings.Trim(ss[2], "\r\t")
 	}
 
-	req, err = http.NewRequest(primitive, url, strings.NewReader(body))
+	var trace requestTrace
+	ctx := httptrace.WithClientTrace(context.Background(), trace.clientTrace())
+	reqBody, err := gompet.Encode(*httpRequestEncoding, []byte(body))
 	if err != nil {
 		return &gompet.ClientResult{Err: err}
 	}
+	req, err = http.NewRequestWithContext(ctx, primitive, url, bytes.NewReader(reqBody))
+	if err != nil {
+		return &gompet.ClientResult{Err: err}
+	}
+
+	// last request allowed on the connection asks the server to close it
+	req.Close = *connReuse > 0 && c.connReqs+1 >= *connReuse
 
 	if body != "" {
 		req.Header.Add("Content-Type", *httpContentType)
+		if *httpRequestEncoding != "" {
+			req.Header.Add("Content-Encoding", *httpRequestEncoding)
+		}
+	}
+	if *httpAcceptEncoding != "" {
+		req.Header.Add("Accept-Encoding", *httpAcceptEncoding)
 	}
 	if *httpAuth != "" {
 		req.Header.Add("Authorization", *httpAuth)
 	}
 
 	var start = time.Now()
+	c.hops = nil
+	c.hopStart = start
 	resp, err = c.httpClient.Do(req)
+	if *httpRedirectHops {
+		start = c.hopStart // the final result only includes the last hop
+	}
 	elapsed := time.Since(start).Seconds()
+	conns := trace.getConns()
+	c.countConnReqs(conns, req.Close)
 	if err != nil {
-		return &gompet.ClientResult{Err: err, Time: elapsed}
+		return &gompet.ClientResult{Err: err, Time: elapsed, Conns: conns, Timings: trace.getTimings(), Extra: c.hops}
 	}
 	defer resp.Body.Close()
+	var bodyStart = time.Now()
 
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+	var respBytes, decoded int64
+	if *httpAcceptEncoding != "" {
+		counter := &countingReader{r: resp.Body}
+		decoded, err = gompet.DecodedLength(resp.Header.Get("Content-Encoding"), counter)
+		respBytes = counter.n
+		if err != nil {
+			return &gompet.ClientResult{Err: err, Time: time.Since(start).Seconds(), Conns: conns,
+				Timings: trace.getTimings(), ReqBytes: int64(len(reqBody)), RespBytes: respBytes, Extra: c.hops}
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			log.Printf("%d http response status %d", c.config.ID, resp.StatusCode)
+		}
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		body, _ := ioutil.ReadAll(resp.Body)
+		respBytes = int64(len(body))
 		s := strings.ReplaceAll(string(body), "\n", " ")
 		log.Printf("%d http response status %d body '%s'", c.config.ID, resp.StatusCode, s)
 	} else {
 		// body, _ := ioutil.ReadAll(resp.Body)
 		// log.Printf("%s: %s\n", cmd, body)
-		io.Copy(ioutil.Discard, resp.Body)
+		respBytes, _ = io.Copy(ioutil.Discard, resp.Body)
 	}
 	elapsed = time.Since(start).Seconds() // final time will include body read time
+	if *httpPhases {
+		trace.add("Body", &bodyStart)
+	}
 	var res = resp.Status
 	if c.config.Verbose {
 		log.Printf("%d http %s: %s", c.config.ID, cmd, res)
 	}
-	return &gompet.ClientResult{Res: res, Time: elapsed}
+	return &gompet.ClientResult{Res: res, Time: elapsed, Conns: conns, Timings: trace.getTimings(),
+		ReqBytes: int64(len(reqBody)), RespBytes: respBytes, Decoded: decoded, Extra: c.hops}
+}
+
+// countingReader counts the compressed bytes read from the response body
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countConnReqs keeps track of requests sent on the current connection for -conn-reuse
+func (c *myClient) countConnReqs(newConns int, closed bool) {
+	switch {
+	case closed:
+		c.connReqs = 0
+	case newConns > 0:
+		c.connReqs = 1
+	default:
+		c.connReqs++
+	}
 }
 
 func (c *myClient) Term() {
@@ -0,0 +1,194 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jkarjala/gompet"
+)
+
+func newClient(t *testing.T) *myClient {
+	client, err := clientFactory(gompet.ClientConfig{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.(*myClient)
+}
+
+// timings returns the phase timings of the result by name, failing on duplicates
+func timings(t *testing.T, res *gompet.ClientResult) map[string]float64 {
+	m := make(map[string]float64)
+	for _, timing := range res.Timings {
+		if _, ok := m[timing.Name]; ok && timing.Name != "Connect" { // a failed IPv6 dial may add one
+			t.Errorf("duplicate timing %s: %+v", timing.Name, res.Timings)
+		}
+		m[timing.Name] = timing.Time
+	}
+	return m
+}
+
+func TestPhases(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("first"))
+		w.(http.Flusher).Flush()
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("second"))
+	}))
+	defer s.Close()
+	*httpPhases = true
+	defer func() { *httpPhases = false }()
+	c := newClient(t)
+	defer c.Term()
+	url := strings.Replace(s.URL, "127.0.0.1", "localhost", 1) // resolved to get the DNS phase
+
+	res := c.RunCommand(&gompet.ClientInput{Cmd: "GET " + url})
+	if res.Err != nil || res.Res != "200 OK" || res.Conns != 1 || res.RespBytes != 11 {
+		t.Fatalf("first: %+v", res)
+	}
+	m := timings(t, res)
+	for _, name := range []string{"DNS", "Connect", "TLS handshake", "TTFB", "Body"} {
+		if v, ok := m[name]; !ok || v < 0 || v > res.Time {
+			t.Errorf("%s = %v, %v, total %v", name, v, ok, res.Time)
+		}
+	}
+	if m["TTFB"] < 0.02 || m["Body"] < 0.02 {
+		t.Errorf("TTFB %v and Body %v should include the server delays", m["TTFB"], m["Body"])
+	}
+
+	res = c.RunCommand(&gompet.ClientInput{Cmd: "GET " + url})
+	if res.Err != nil || res.Conns != 0 {
+		t.Fatalf("reused: %+v", res)
+	}
+	m = timings(t, res)
+	if len(m) != 2 || m["TTFB"] < 0.02 || m["Body"] < 0.02 {
+		t.Errorf("reused connection timings: %+v", res.Timings)
+	}
+}
+
+func TestConnReuse(t *testing.T) {
+	var conns int32
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	s.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	defer func() { *connReuse = 0; *connKeepAlive = true }()
+
+	for _, tc := range []struct {
+		reuse     int
+		keepAlive bool
+		want      int32
+	}{{0, true, 1}, {1, true, 6}, {2, true, 3}, {4, true, 2}, {0, false, 6}} {
+		*connReuse = tc.reuse
+		*connKeepAlive = tc.keepAlive
+		atomic.StoreInt32(&conns, 0)
+		c := newClient(t)
+		var clientConns int
+		for i := 0; i < 6; i++ {
+			res := c.RunCommand(&gompet.ClientInput{Cmd: "GET " + s.URL})
+			if res.Err != nil {
+				t.Fatalf("%+v: %v", tc, res.Err)
+			}
+			clientConns += res.Conns
+		}
+		c.Term()
+		if n := atomic.LoadInt32(&conns); n != tc.want || clientConns != int(tc.want) {
+			t.Errorf("%+v: server got %d connections, client reported %d", tc, n, clientConns)
+		}
+	}
+}
+
+// startRedirects starts a server where /r/N redirects to /r/N-1 after 10ms, /login sets a cookie
+// and /whoami requires it
+func startRedirects() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/r/", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/r/"))
+		if n > 0 {
+			http.Redirect(w, r, "/r/"+strconv.Itoa(n-1), http.StatusFound)
+		}
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "gompet"})
+	})
+	mux.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "gompet" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestRedirects(t *testing.T) {
+	s := startRedirects()
+	defer s.Close()
+	defer func() { *httpMaxRedirects = 10; *httpRedirectHops = false }()
+	c := newClient(t)
+	defer c.Term()
+
+	*httpMaxRedirects = 0
+	if res := c.RunCommand(&gompet.ClientInput{Cmd: "GET " + s.URL + "/r/2"}); res.Err != nil || res.Res != "302 Found" {
+		t.Errorf("no redirects: %+v", res)
+	}
+	*httpMaxRedirects = 2
+	if res := c.RunCommand(&gompet.ClientInput{Cmd: "GET " + s.URL + "/r/2"}); res.Err != nil || res.Res != "200 OK" || res.Extra != nil {
+		t.Errorf("redirect limit: %+v", res)
+	}
+	res := c.RunCommand(&gompet.ClientInput{Cmd: "GET " + s.URL + "/r/3"})
+	if res.Err == nil || !strings.Contains(res.Err.Error(), "stopped after 2 redirects") {
+		t.Errorf("over redirect limit: %+v", res)
+	}
+
+	*httpRedirectHops = true
+	res = c.RunCommand(&gompet.ClientInput{Cmd: "GET " + s.URL + "/r/2"})
+	if res.Err != nil || res.Res != "200 OK" || len(res.Extra) != 2 {
+		t.Fatalf("redirect hops: %+v", res)
+	}
+	for _, hop := range append(res.Extra, res) {
+		if hop.Time < 0.01 || hop.Time >= 0.025 {
+			t.Errorf("hop %s time %v should include only its own server delay", hop.Res, hop.Time)
+		}
+	}
+	if res.Extra[0].Res != "302 Found" || res.Extra[1].Res != "302 Found" {
+		t.Errorf("hop results %+v %+v", res.Extra[0], res.Extra[1])
+	}
+}
+
+func TestCookies(t *testing.T) {
+	s := startRedirects()
+	defer s.Close()
+	c := newClient(t)
+	defer c.Term()
+	c.RunCommand(&gompet.ClientInput{Cmd: "GET " + s.URL + "/login"})
+	if res := c.RunCommand(&gompet.ClientInput{Cmd: "GET " + s.URL + "/whoami"}); res.Res != "401 Unauthorized" {
+		t.Errorf("without -cookies: %+v", res)
+	}
+
+	*httpCookies = true
+	defer func() { *httpCookies = false }()
+	c = newClient(t)
+	defer c.Term()
+	c.RunCommand(&gompet.ClientInput{Cmd: "GET " + s.URL + "/login"})
+	if res := c.RunCommand(&gompet.ClientInput{Cmd: "GET " + s.URL + "/whoami"}); res.Res != "200 OK" {
+		t.Errorf("cookie not sent back: %+v", res)
+	}
+	other := newClient(t)
+	defer other.Term()
+	if res := other.RunCommand(&gompet.ClientInput{Cmd: "GET " + s.URL + "/whoami"}); res.Res != "401 Unauthorized" {
+		t.Errorf("cookie shared by clients: %+v", res)
+	}
+}
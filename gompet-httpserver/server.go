@@ -11,20 +11,37 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/fasthttp/websocket"
 	"github.com/valyala/fasthttp"
 )
 
 var listenAddr = flag.String("a", "127.0.0.1:4200", "Address and port to listen")
+var compress = flag.Bool("compress", false, "Compress responses with br or gzip if accepted by client")
+var config = flag.String("config", "", "File with lines 'path params' to simulate behavior, e.g. '/slow delay=50ms&error=0.01'")
 
 func main() {
 	flag.Parse()
+	if *config != "" {
+		if err := loadBehaviors(*config); err != nil {
+			log.Fatalf("error in config: %s", err)
+		}
+	}
 	fmt.Println("Server listening at", *listenAddr)
-	if err := fasthttp.ListenAndServe(*listenAddr, requestHandler); err != nil {
+	handler := requestHandler
+	if *compress {
+		handler = fasthttp.CompressHandlerBrotliLevel(handler, fasthttp.CompressBrotliDefaultCompression, fasthttp.CompressDefaultCompression)
+	}
+	handler = statsHandler(handler)
+	if err := fasthttp.ListenAndServe(*listenAddr, handler); err != nil {
 		log.Fatalf("error in ListenAndServe: %s", err)
 	}
 }
 
 func requestHandler(ctx *fasthttp.RequestCtx) {
+	if b, ok := behaviors[string(ctx.Path())]; ok {
+		simulate(ctx, b, false) // the application may have its own parameters
+		return
+	}
 	switch s := string(ctx.Path()); s {
 	case "/":
 		ctx.SetContentType("text/plain")
@@ -36,20 +53,71 @@ func requestHandler(ctx *fasthttp.RequestCtx) {
 		ctx.SetContentType(string(ctx.Request.Header.ContentType()))
 		ctx.Write([]byte(s))
 		ctx.Write([]byte(":"))
-		ctx.Write(ctx.Request.Body())
+		body, err := ctx.Request.BodyUncompressed()
+		if err != nil {
+			ctx.Error(err.Error(), 400)
+			return
+		}
+		ctx.Write(body)
+	case "/sim":
+		simulate(ctx, defaultBehavior, true)
 	case "/snoop":
 		snoop(ctx)
+	case "/ws":
+		wsEcho(ctx)
+	case "/login":
+		var cookie fasthttp.Cookie
+		cookie.SetKey("session")
+		cookie.SetValue(strconv.FormatUint(ctx.ID(), 10))
+		ctx.Response.Header.SetCookie(&cookie)
+		ctx.SetContentType("text/plain")
+		ctx.Write([]byte("OK"))
+	case "/session":
+		if len(ctx.Request.Header.Cookie("session")) == 0 {
+			ctx.SetStatusCode(401)
+			return
+		}
+		ctx.SetContentType("text/plain")
+		ctx.Write([]byte("OK"))
 	default:
 		parts := strings.Split(s, "/")
 		if parts[1] == "status" && len(parts) > 2 {
 			code, _ := strconv.Atoi(parts[2])
 			ctx.SetStatusCode(code)
+		} else if parts[1] == "redirect" && len(parts) > 2 {
+			count, _ := strconv.Atoi(parts[2])
+			if count > 1 {
+				ctx.Redirect(fmt.Sprintf("/redirect/%d", count-1), 302)
+			} else {
+				ctx.Redirect("/", 302)
+			}
 		} else {
 			ctx.SetStatusCode(404)
 		}
 	}
 }
 
+var upgrader = websocket.FastHTTPUpgrader{}
+
+// wsEcho sends each WebSocket message back to the client
+func wsEcho(ctx *fasthttp.RequestCtx) {
+	err := upgrader.Upgrade(ctx, func(conn *websocket.Conn) {
+		defer conn.Close()
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err = conn.WriteMessage(messageType, message); err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		log.Println("websocket upgrade failed:", err)
+	}
+}
+
 func snoop(ctx *fasthttp.RequestCtx) {
 	fmt.Fprintf(ctx, "Request method is %q\n", ctx.Method())
 	fmt.Fprintf(ctx, "RequestURI is %q\n", ctx.RequestURI())
@@ -57,7 +125,7 @@ func snoop(ctx *fasthttp.RequestCtx) {
 	fmt.Fprintf(ctx, "Host is %q\n", ctx.Host())
 	fmt.Fprintf(ctx, "Query string is %q\n", ctx.QueryArgs())
 	fmt.Fprintf(ctx, "Content-Type is %q\n", ctx.Request.Header.ContentType())
-	fmt.Fprintf(ctx, "Content-Length is %q\n", ctx.Request.Header.ContentLength())
+	fmt.Fprintf(ctx, "Content-Length is %d\n", ctx.Request.Header.ContentLength())
 	fmt.Fprintf(ctx, "User-Agent is %q\n", ctx.UserAgent())
 	fmt.Fprintf(ctx, "Connection has been established at %s\n", ctx.ConnTime())
 	fmt.Fprintf(ctx, "Request has been started at %s\n", ctx.Time())
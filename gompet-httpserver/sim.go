@@ -0,0 +1,222 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// behavior is the simulated behavior of an endpoint, configured with query parameters
+// like delay=normal:50ms:10ms&error=0.01&size=1000
+type behavior struct {
+	delay       func() time.Duration // response delay, nil for none
+	errorRate   float64              // share of requests failed with errorStatus
+	errorStatus int
+	resetRate   float64 // share of connections reset without response
+	status      int
+	size        int           // response body size, -1 for "OK"
+	drip        time.Duration // delay between body chunks, 0 sends the body at once
+	chunk       int           // body chunk size when dripping
+}
+
+var defaultBehavior = behavior{errorStatus: 500, status: 200, size: -1, chunk: 100}
+
+// behaviors are the configured paths, clients can override them with query parameters
+var behaviors = make(map[string]behavior)
+
+var payload = bytes.Repeat([]byte("gompet payload\n"), 1<<16)
+
+// loadBehaviors reads the file of lines 'path params', empty lines and #comments are skipped
+func loadBehaviors(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected 'path params'", filename, line)
+		}
+		var args fasthttp.Args
+		args.Parse(fields[1])
+		b, err := parseBehavior(defaultBehavior, &args, true)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", filename, line, err)
+		}
+		behaviors[fields[0]] = b
+	}
+	return scanner.Err()
+}
+
+// parseBehavior returns the base behavior modified by the parameters. Unknown parameters
+// are errors if strict, otherwise they are ignored, e.g. the ids of configured paths.
+func parseBehavior(b behavior, args *fasthttp.Args, strict bool) (behavior, error) {
+	var err error
+	args.VisitAll(func(key, value []byte) {
+		if err != nil {
+			return
+		}
+		v := string(value)
+		switch string(key) {
+		case "delay":
+			b.delay, err = parseDelay(v)
+		case "error":
+			b.errorRate, err = strconv.ParseFloat(v, 64)
+		case "error-status":
+			b.errorStatus, err = strconv.Atoi(v)
+		case "reset":
+			b.resetRate, err = strconv.ParseFloat(v, 64)
+		case "status":
+			b.status, err = strconv.Atoi(v)
+		case "size":
+			b.size, err = strconv.Atoi(v)
+		case "drip":
+			b.drip, err = time.ParseDuration(v)
+		case "chunk":
+			b.chunk, err = strconv.Atoi(v)
+			if err == nil && b.chunk < 1 {
+				err = fmt.Errorf("chunk must be at least 1")
+			}
+		default:
+			if strict {
+				err = fmt.Errorf("unknown parameter '%s'", key)
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid %s=%s: %v", key, value, err)
+		}
+	})
+	return b, err
+}
+
+// parseDelay parses a fixed delay like 50ms, or a distribution:
+// normal:mean:stddev, lognormal:median:sigma or bimodal:fast:slow:slowshare
+func parseDelay(spec string) (func() time.Duration, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) == 1 {
+		d, err := time.ParseDuration(spec)
+		return func() time.Duration { return d }, err
+	}
+	switch {
+	case parts[0] == "normal" && len(parts) == 3:
+		mean, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		stddev, err := time.ParseDuration(parts[2])
+		return func() time.Duration {
+			return time.Duration(math.Max(0, float64(mean)+rand.NormFloat64()*float64(stddev)))
+		}, err
+	case parts[0] == "lognormal" && len(parts) == 3:
+		median, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		sigma, err := strconv.ParseFloat(parts[2], 64)
+		return func() time.Duration {
+			return time.Duration(float64(median) * math.Exp(rand.NormFloat64()*sigma))
+		}, err
+	case parts[0] == "bimodal" && len(parts) == 4:
+		fast, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		slow, err := time.ParseDuration(parts[2])
+		if err != nil {
+			return nil, err
+		}
+		share, err := strconv.ParseFloat(parts[3], 64)
+		return func() time.Duration {
+			if rand.Float64() < share {
+				return slow
+			}
+			return fast
+		}, err
+	default:
+		return nil, fmt.Errorf("use normal:mean:stddev, lognormal:median:sigma or bimodal:fast:slow:share")
+	}
+}
+
+// simulate responds according to the behavior, the query parameters override it
+func simulate(ctx *fasthttp.RequestCtx, b behavior, strict bool) {
+	b, err := parseBehavior(b, ctx.QueryArgs(), strict)
+	if err != nil {
+		ctx.Error(err.Error(), 400)
+		return
+	}
+	if b.resetRate > 0 && rand.Float64() < b.resetRate {
+		reset(ctx)
+		return
+	}
+	if b.delay != nil {
+		time.Sleep(b.delay())
+	}
+	if b.errorRate > 0 && rand.Float64() < b.errorRate {
+		ctx.Error("Simulated error", b.errorStatus)
+		return
+	}
+	ctx.SetStatusCode(b.status)
+	ctx.SetContentType("text/plain")
+	if b.size < 0 {
+		ctx.Write([]byte("OK"))
+		return
+	}
+	if b.drip > 0 {
+		size, chunk, drip := b.size, b.chunk, b.drip
+		ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+			for sent := 0; sent < size; sent += chunk {
+				if sent > 0 {
+					time.Sleep(drip)
+				}
+				writeBody(w, int(math.Min(float64(chunk), float64(size-sent))))
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		})
+		return
+	}
+	writeBody(ctx, b.size)
+}
+
+// writeBody writes size bytes of the payload text
+func writeBody(w io.Writer, size int) {
+	for size > 0 {
+		n := size
+		if n > len(payload) {
+			n = len(payload)
+		}
+		w.Write(payload[:n])
+		size -= n
+	}
+}
+
+// reset closes the connection with TCP RST instead of sending a response
+func reset(ctx *fasthttp.RequestCtx) {
+	conn := ctx.Conn()
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(net.Conn) {
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetLinger(0)
+		}
+		conn.Close()
+	})
+}
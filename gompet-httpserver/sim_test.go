@@ -0,0 +1,66 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestParseDelay(t *testing.T) {
+	for _, spec := range []string{"", "normal:5ms", "lognormal:5ms:x", "bimodal:1ms:5ms", "uniform:1ms:2ms"} {
+		if _, err := parseDelay(spec); err == nil {
+			t.Errorf("parseDelay accepted '%s'", spec)
+		}
+	}
+	fixed, err := parseDelay("5ms")
+	if err != nil || fixed() != 5*time.Millisecond {
+		t.Errorf("fixed delay error %v", err)
+	}
+	bimodal, err := parseDelay("bimodal:1ms:5ms:0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[time.Duration]int)
+	for i := 0; i < 1000; i++ {
+		counts[bimodal()]++
+	}
+	if len(counts) != 2 || counts[time.Millisecond] < 400 || counts[5*time.Millisecond] < 400 {
+		t.Errorf("bimodal delays = %v", counts)
+	}
+	normal, err := parseDelay("normal:1ms:5ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if d := normal(); d < 0 {
+			t.Fatalf("negative normal delay %v", d)
+		}
+	}
+}
+
+func TestParseBehavior(t *testing.T) {
+	var args fasthttp.Args
+	args.Parse("delay=lognormal:10ms:0.5&error=0.1&error-status=503&size=1000&drip=5ms&chunk=10")
+	b, err := parseBehavior(defaultBehavior, &args, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.delay == nil || b.errorRate != 0.1 || b.errorStatus != 503 || b.size != 1000 ||
+		b.drip != 5*time.Millisecond || b.chunk != 10 || b.status != 200 {
+		t.Errorf("behavior = %+v", b)
+	}
+	for _, params := range []string{"size=x", "chunk=0", "colour=red"} {
+		args.Parse(params)
+		if _, err = parseBehavior(defaultBehavior, &args, true); err == nil {
+			t.Errorf("parseBehavior accepted '%s'", params)
+		}
+	}
+	args.Parse("id=5&status=201")
+	if b, err = parseBehavior(defaultBehavior, &args, false); err != nil || b.status != 201 {
+		t.Errorf("non-strict behavior = %+v, %v", b, err)
+	}
+}
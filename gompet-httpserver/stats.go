@@ -0,0 +1,92 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jkarjala/gompet/stats"
+	"github.com/valyala/fasthttp"
+)
+
+// serverStats counts the requests and their service times, excluding the admin endpoints
+type serverStats struct {
+	mu          sync.Mutex
+	start       time.Time
+	requests    int64
+	inFlight    int64
+	maxInFlight int64
+	statuses    map[int]int64
+	times       stats.Histogram
+}
+
+var requestStats = serverStats{start: time.Now(), statuses: make(map[int]int64)}
+
+// statsHandler records the stats of the handler and serves them at /admin/stats,
+// with ?reset=1 the stats are reset after the snapshot
+func statsHandler(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		path := string(ctx.Path())
+		if path == "/admin/stats" {
+			reset := ctx.QueryArgs().GetBool("reset")
+			body, _ := json.Marshal(requestStats.snapshot(reset))
+			ctx.SetContentType("application/json")
+			ctx.Write(body)
+			return
+		}
+		if strings.HasPrefix(path, "/admin/") {
+			handler(ctx)
+			return
+		}
+		start := requestStats.begin()
+		handler(ctx)
+		requestStats.end(start, ctx.Response.StatusCode())
+	}
+}
+
+func (s *serverStats) begin() time.Time {
+	s.mu.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	s.mu.Unlock()
+	return time.Now()
+}
+
+// end records the service time, which excludes streamed response bodies
+func (s *serverStats) end(start time.Time, status int) {
+	elapsed := time.Since(start).Seconds()
+	s.mu.Lock()
+	s.inFlight--
+	s.requests++
+	s.statuses[status]++
+	s.times.Add(elapsed)
+	s.mu.Unlock()
+}
+
+func (s *serverStats) snapshot(reset bool) *stats.ServerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := stats.NewServerStats(&s.times)
+	snapshot.Elapsed = time.Since(s.start).Seconds()
+	snapshot.Requests = s.requests
+	snapshot.InFlight = s.inFlight
+	snapshot.MaxInFlight = s.maxInFlight
+	for status, count := range s.statuses {
+		snapshot.Statuses[strconv.Itoa(status)] = count
+	}
+	if reset {
+		s.start = time.Now()
+		s.requests = 0
+		s.maxInFlight = s.inFlight
+		s.statuses = make(map[int]int64)
+		s.times.Reset()
+	}
+	return snapshot
+}
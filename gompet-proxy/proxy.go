@@ -0,0 +1,287 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+// Fault-injecting TCP proxy for testing the clients and services under degraded networks
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var listenAddr = flag.String("a", "127.0.0.1:4203", "Address and port to listen")
+var targetAddr = flag.String("target", "", "Target address and port, e.g. 127.0.0.1:4200")
+var faultSpec = flag.String("faults", "", "Faults to inject, e.g. 'latency=50ms&jitter=10ms&drop=0.01'")
+var scheduleFile = flag.String("schedule", "", "File with lines 'duration faults' to inject in a cycle, overrides -faults")
+var verbose = flag.Bool("v", false, "Log each injected fault")
+
+// faults are the network degradations to inject, the shares are probabilities
+type faults struct {
+	latency   time.Duration // one-way delay of each chunk of data
+	jitter    time.Duration // random additional delay up to given duration
+	bandwidth int           // bytes/sec per connection and direction, 0 for unlimited
+	drop      float64       // share of chunks of data lost
+	reset     float64       // share of chunks causing a connection reset
+	blackhole float64       // share of connections whose data is discarded, 1 discards all data
+}
+
+// phase is one step of the fault schedule
+type phase struct {
+	duration time.Duration
+	faults   faults
+	spec     string
+}
+
+var current atomic.Value // *faults injected now
+
+// chunk is the data of one read, forwarded when due
+type chunk struct {
+	data []byte
+	due  time.Time
+}
+
+func main() {
+	flag.Parse()
+	if *targetAddr == "" {
+		fmt.Println("Missing -target")
+		os.Exit(1)
+	}
+	f, err := parseFaults(*faultSpec)
+	if err != nil {
+		fmt.Println("Invalid -faults:", err)
+		os.Exit(1)
+	}
+	current.Store(&f)
+	if *scheduleFile != "" {
+		schedule, err := loadSchedule(*scheduleFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		go runSchedule(schedule)
+	}
+
+	lis, err := net.Listen("tcp", *listenAddr)
+	if err != nil {
+		log.Fatalf("error in Listen: %s", err)
+	}
+	fmt.Println("Proxy listening at", *listenAddr, "for", *targetAddr)
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			log.Fatalf("error in Accept: %s", err)
+		}
+		go proxy(conn, *targetAddr)
+	}
+}
+
+// parseFaults parses the faults from query parameter syntax
+func parseFaults(spec string) (faults, error) {
+	var f faults
+	values, err := url.ParseQuery(spec)
+	if err != nil {
+		return f, err
+	}
+	for key := range values {
+		v := values.Get(key)
+		switch key {
+		case "latency":
+			f.latency, err = time.ParseDuration(v)
+		case "jitter":
+			f.jitter, err = time.ParseDuration(v)
+		case "bandwidth":
+			f.bandwidth, err = strconv.Atoi(v)
+		case "drop":
+			f.drop, err = strconv.ParseFloat(v, 64)
+		case "reset":
+			f.reset, err = strconv.ParseFloat(v, 64)
+		case "blackhole":
+			f.blackhole, err = strconv.ParseFloat(v, 64)
+		default:
+			err = errors.New("unknown fault")
+		}
+		if err != nil {
+			return f, fmt.Errorf("%s=%s: %v", key, v, err)
+		}
+	}
+	return f, nil
+}
+
+// loadSchedule reads the file of lines 'duration faults', empty lines and #comments are skipped
+func loadSchedule(filename string) ([]phase, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var schedule []phase
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var p phase
+		if p.duration, err = time.ParseDuration(fields[0]); err != nil || p.duration <= 0 {
+			return nil, fmt.Errorf("%s:%d: invalid duration '%s'", filename, line, fields[0])
+		}
+		if len(fields) > 1 {
+			p.spec = fields[1]
+		}
+		if p.faults, err = parseFaults(p.spec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, line, err)
+		}
+		schedule = append(schedule, p)
+	}
+	if err = scanner.Err(); err == nil && len(schedule) == 0 {
+		err = fmt.Errorf("%s: empty schedule", filename)
+	}
+	return schedule, err
+}
+
+// runSchedule injects the faults of each phase in turn, forever
+func runSchedule(schedule []phase) {
+	for {
+		for i := range schedule {
+			p := &schedule[i]
+			fmt.Printf("%s %s for %s\n", time.Now().Format("15:04:05"), describe(p.spec), p.duration)
+			current.Store(&p.faults)
+			time.Sleep(p.duration)
+		}
+	}
+}
+
+func describe(spec string) string {
+	if spec == "" {
+		return "no faults"
+	}
+	return spec
+}
+
+// proxy forwards the data between the client and a new target connection
+func proxy(client net.Conn, target string) {
+	defer client.Close()
+	blackholed := rand.Float64() < current.Load().(*faults).blackhole
+	if blackholed {
+		logFault("blackhole", client)
+	}
+	server, err := net.Dial("tcp", target)
+	if err != nil {
+		log.Println("error in Dial:", err)
+		return
+	}
+	defer server.Close()
+
+	var once sync.Once
+	aborted := make(chan bool)
+	abort := func() { once.Do(func() { close(aborted) }) }
+	finished := make(chan bool, 2)
+	go forward(client, server, blackholed, finished, abort)
+	go forward(server, client, blackholed, finished, abort)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-finished:
+		case <-aborted:
+			return
+		}
+	}
+}
+
+// forward copies the data from src to dst injecting the current faults. The delayed data
+// is written by another goroutine. When src has ended and all data is written, dst is
+// half-closed to pass on the EOF and finished is signaled. If either connection fails,
+// abort is called. The connections are closed after both directions finish or on abort.
+func forward(src, dst net.Conn, blackholed bool, finished chan bool, abort func()) {
+	chunks := make(chan chunk, 64)
+	var readErr error // set before chunks is closed
+	go func() {
+		var err error
+		for c := range chunks {
+			if err != nil {
+				continue // drain until the reader stops
+			}
+			if d := time.Until(c.due); d > 0 {
+				time.Sleep(d)
+			}
+			if _, err = dst.Write(c.data); err != nil {
+				abort()
+				continue
+			}
+			if bandwidth := current.Load().(*faults).bandwidth; bandwidth > 0 {
+				time.Sleep(time.Duration(len(c.data)) * time.Second / time.Duration(bandwidth))
+			}
+		}
+		if err != nil || readErr != io.EOF {
+			abort()
+			return
+		}
+		closeWrite(dst)
+		finished <- true
+	}()
+	defer close(chunks)
+
+	for {
+		buf := make([]byte, 16384)
+		n, err := src.Read(buf)
+		if n > 0 {
+			f := current.Load().(*faults)
+			switch {
+			case blackholed || f.blackhole >= 1:
+				// discard
+			case f.reset > 0 && rand.Float64() < f.reset:
+				logFault("reset", src)
+				resetConn(src)
+				resetConn(dst)
+				return // no EOF, the writer aborts
+			case f.drop > 0 && rand.Float64() < f.drop:
+				logFault("drop", src)
+			default:
+				delay := f.latency
+				if f.jitter > 0 {
+					delay += time.Duration(rand.Int63n(int64(f.jitter)))
+				}
+				chunks <- chunk{buf[:n], time.Now().Add(delay)}
+			}
+		}
+		if err != nil {
+			readErr = err
+			return
+		}
+	}
+}
+
+// closeWrite half-closes the connection, the peer reads EOF but can still send
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	} else {
+		conn.Close()
+	}
+}
+
+// resetConn closes the connection with TCP RST
+func resetConn(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+func logFault(fault string, conn net.Conn) {
+	if *verbose {
+		fmt.Printf("%s %s %s\n", time.Now().Format("15:04:05.000"), fault, conn.RemoteAddr())
+	}
+}
@@ -0,0 +1,134 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseFaults(t *testing.T) {
+	f, err := parseFaults("latency=50ms&jitter=5ms&bandwidth=1000&drop=0.1&reset=0.01&blackhole=1")
+	want := faults{50 * time.Millisecond, 5 * time.Millisecond, 1000, 0.1, 0.01, 1}
+	if err != nil || f != want {
+		t.Errorf("parseFaults = %+v, %v", f, err)
+	}
+	for _, spec := range []string{"latency=x", "drop=y", "loss=0.1"} {
+		if _, err = parseFaults(spec); err == nil {
+			t.Errorf("parseFaults accepted '%s'", spec)
+		}
+	}
+}
+
+func TestLoadSchedule(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "schedule.txt")
+	ioutil.WriteFile(filename, []byte("# warmup\n10s\n\n5s latency=100ms&drop=0.01\n1m blackhole=1\n"), 0644)
+	schedule, err := loadSchedule(filename)
+	if err != nil || len(schedule) != 3 {
+		t.Fatalf("loadSchedule = %v, %v", schedule, err)
+	}
+	if schedule[0].duration != 10*time.Second || schedule[0].faults != (faults{}) ||
+		schedule[1].faults.latency != 100*time.Millisecond || schedule[2].faults.blackhole != 1 {
+		t.Errorf("schedule = %+v", schedule)
+	}
+	ioutil.WriteFile(filename, []byte("5 latency=1ms\n"), 0644)
+	if _, err = loadSchedule(filename); err == nil {
+		t.Error("loadSchedule accepted duration without unit")
+	}
+}
+
+// startProxy starts an echo server and a proxy for it, and returns the proxy address
+func startProxy(t *testing.T) string {
+	echo := listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	})
+	return listen(t, func(conn net.Conn) { proxy(conn, echo) })
+}
+
+func listen(t *testing.T, serve func(net.Conn)) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return lis.Addr().String()
+}
+
+// roundTrip sends a message through the proxy and returns the reply and time taken
+func roundTrip(t *testing.T, addr string, f faults) (string, time.Duration, error) {
+	current.Store(&f)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	start := time.Now()
+	conn.SetDeadline(start.Add(500 * time.Millisecond))
+	conn.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	return string(buf), time.Since(start), err
+}
+
+func TestProxyFaults(t *testing.T) {
+	defer current.Store(&faults{})
+	addr := startProxy(t)
+
+	reply, elapsed, err := roundTrip(t, addr, faults{})
+	if err != nil || reply != "hello" {
+		t.Errorf("no faults: '%s' %v", reply, err)
+	}
+	reply, elapsed, err = roundTrip(t, addr, faults{latency: 50 * time.Millisecond})
+	if err != nil || reply != "hello" || elapsed < 100*time.Millisecond {
+		t.Errorf("latency: '%s' in %v, %v", reply, elapsed, err)
+	}
+	if _, _, err = roundTrip(t, addr, faults{drop: 1}); !os.IsTimeout(err) {
+		t.Errorf("drop: %v, want timeout", err)
+	}
+	if _, _, err = roundTrip(t, addr, faults{blackhole: 1}); !os.IsTimeout(err) {
+		t.Errorf("blackhole: %v, want timeout", err)
+	}
+	if _, _, err = roundTrip(t, addr, faults{reset: 1}); err == nil || os.IsTimeout(err) {
+		t.Errorf("reset: %v, want connection reset", err)
+	}
+}
+
+func TestProxyHalfClose(t *testing.T) {
+	// the server replies after the client has sent all and half-closed its side
+	server := listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		data, _ := ioutil.ReadAll(conn)
+		time.Sleep(20 * time.Millisecond)
+		conn.Write(data)
+	})
+	addr := listen(t, func(conn net.Conn) { proxy(conn, server) })
+	current.Store(&faults{})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(500 * time.Millisecond))
+	conn.Write([]byte("hello"))
+	conn.(*net.TCPConn).CloseWrite()
+	if reply, err := ioutil.ReadAll(conn); err != nil || string(reply) != "hello" {
+		t.Errorf("reply after half-close: '%s', %v", reply, err)
+	}
+}
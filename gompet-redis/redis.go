@@ -0,0 +1,247 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+// Redis Client speaking the RESP protocol directly, with optional pipelining
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jkarjala/gompet"
+)
+
+// Long options for the testers, short ones used by the main library
+var redisAddr = flag.String("addr", "localhost:6379", "Redis server address")
+var redisAuth = flag.String("auth", "", "Password for AUTH after connect")
+var redisDB = flag.Int("db", 0, "Database number for SELECT after connect")
+var redisPipeline = flag.Int("pipeline", 1, "Send N commands before reading the replies")
+var redisTimeout = flag.Int("timeout", 10, "Connect and reply timeout in seconds")
+
+func main() {
+	log.Println("Redis tester started")
+	gompet.Run(clientFactory)
+}
+
+type myClient struct {
+	config  gompet.ClientConfig
+	conn    net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	pending [][]string // commands waiting to be pipelined
+}
+
+func clientFactory(config gompet.ClientConfig) (gompet.Client, error) {
+	log.Println(config.ID, "redis init")
+	if *redisPipeline < 1 {
+		return nil, errors.New("Pipeline depth must be at least 1")
+	}
+	var client = myClient{config: config}
+	return &client, nil
+}
+
+// connect opens the connection and authenticates and selects the database if requested
+func (c *myClient) connect() error {
+	conn, err := net.DialTimeout("tcp", *redisAddr, time.Duration(*redisTimeout)*time.Second)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	c.writer = bufio.NewWriter(conn)
+
+	var setup [][]string
+	if *redisAuth != "" {
+		setup = append(setup, []string{"AUTH", *redisAuth})
+	}
+	if *redisDB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(*redisDB)})
+	}
+	for _, args := range setup {
+		writeCommand(c.writer, args)
+		if err = c.writer.Flush(); err != nil {
+			return err
+		}
+		res, _, err := readReply(c.reader)
+		if err != nil {
+			return err
+		}
+		if res != "OK" {
+			return fmt.Errorf("%s failed: %s", args[0], res)
+		}
+	}
+	return nil
+}
+
+func (c *myClient) RunCommand(in *gompet.ClientInput) *gompet.ClientResult {
+	cmd := in.Cmd
+	if c.config.Template != nil {
+		cmd = c.config.Template.Expand(in.Args)
+	}
+	args, err := splitArgs(cmd)
+	if err != nil {
+		return &gompet.ClientResult{Err: err}
+	}
+	if len(args) == 0 {
+		return &gompet.ClientResult{Err: errors.New("Empty command")}
+	}
+	c.pending = append(c.pending, args)
+	if len(c.pending) < *redisPipeline {
+		return nil // result is returned with the last command of the pipeline
+	}
+	return c.Flush()
+}
+
+// Flush sends the pending commands and reads their replies, the results
+// of all but the last command are returned in Extra of the last result
+func (c *myClient) Flush() *gompet.ClientResult {
+	if len(c.pending) == 0 {
+		return nil
+	}
+	results := make([]*gompet.ClientResult, len(c.pending))
+	var conns int
+	var err error
+	if c.conn == nil {
+		if err = c.connect(); err == nil {
+			conns = 1
+		}
+	}
+
+	var start = time.Now()
+	var reqBytes int64
+	if err == nil {
+		c.conn.SetDeadline(start.Add(time.Duration(*redisTimeout) * time.Second))
+		for _, args := range c.pending {
+			reqBytes += writeCommand(c.writer, args)
+		}
+		err = c.writer.Flush()
+	}
+	for i, args := range c.pending {
+		if err != nil {
+			results[i] = &gompet.ClientResult{Err: err, Time: time.Since(start).Seconds()}
+			continue
+		}
+		var res string
+		var n int64
+		res, n, err = readReply(c.reader)
+		results[i] = &gompet.ClientResult{Res: res, Err: err, Time: time.Since(start).Seconds(), RespBytes: n}
+		if c.config.Verbose {
+			log.Printf("%d redis %s: %s", c.config.ID, strings.Join(args, " "), res)
+		}
+	}
+	if err != nil && c.conn != nil {
+		c.conn.Close() // replies would be out of sync, reconnect for next commands
+		c.conn = nil
+	}
+	c.pending = c.pending[:0]
+
+	last := results[len(results)-1]
+	last.Conns = conns
+	last.ReqBytes = reqBytes
+	last.Extra = results[:len(results)-1]
+	return last
+}
+
+// splitArgs splits the command to arguments at spaces, "quoted" arguments may contain spaces and escapes
+func splitArgs(cmd string) ([]string, error) {
+	var args []string
+	s := strings.TrimSpace(cmd)
+	for len(s) > 0 {
+		var arg string
+		if s[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid quoted argument in '%s'", cmd)
+			}
+			arg, _ = strconv.Unquote(quoted)
+			s = s[len(quoted):]
+		} else {
+			end := strings.IndexAny(s, " \t")
+			if end < 0 {
+				end = len(s)
+			}
+			arg, s = s[:end], s[end:]
+		}
+		args = append(args, arg)
+		s = strings.TrimLeft(s, " \t")
+	}
+	return args, nil
+}
+
+// writeCommand writes the arguments as RESP array of bulk strings and returns the byte count
+func writeCommand(w *bufio.Writer, args []string) int64 {
+	var n int
+	m, _ := fmt.Fprintf(w, "*%d\r\n", len(args))
+	n += m
+	for _, arg := range args {
+		m, _ = fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+		n += m
+	}
+	return int64(n)
+}
+
+// readReply reads one reply and returns its type as the result and the byte count.
+// Simple strings are returned as is, errors with their first word, e.g. "error WRONGTYPE".
+func readReply(r *bufio.Reader) (string, int64, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", int64(len(line)), err
+	}
+	n := int64(len(line))
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return "", n, errors.New("Invalid empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], n, nil
+	case '-':
+		return "error " + strings.SplitN(line[1:], " ", 2)[0], n, nil
+	case ':':
+		return "integer", n, nil
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", n, fmt.Errorf("Invalid bulk length '%s'", line)
+		}
+		if size < 0 {
+			return "nil", n, nil
+		}
+		m, err := io.CopyN(ioutil.Discard, r, int64(size)+2) // include \r\n
+		return "bulk", n + m, err
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", n, fmt.Errorf("Invalid array length '%s'", line)
+		}
+		if count < 0 {
+			return "nil", n, nil
+		}
+		for i := 0; i < count; i++ {
+			_, m, err := readReply(r)
+			n += m
+			if err != nil {
+				return "", n, err
+			}
+		}
+		return "array", n, nil
+	default:
+		return "", n, fmt.Errorf("Invalid reply '%s'", line)
+	}
+}
+
+func (c *myClient) Term() {
+	log.Println(c.config.ID, "redis term")
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
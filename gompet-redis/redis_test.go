@@ -0,0 +1,169 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jkarjala/gompet"
+)
+
+// respServer is a tiny in-process stand-in for Redis with a few commands
+type respServer struct {
+	lis  net.Listener
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func startServer(t *testing.T) *respServer {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &respServer{lis: lis, data: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *respServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		io.WriteString(conn, s.execute(args))
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	var count int
+	if _, err := fmt.Fscanf(r, "*%d\r\n", &count); err != nil {
+		return nil, err
+	}
+	args := make([]string, count)
+	for i := range args {
+		var size int
+		if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func (s *respServer) execute(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "SET":
+		s.data[args[1]] = args[2]
+		return "+OK\r\n"
+	case "GET":
+		v, ok := s.data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "INCR":
+		n, err := strconv.Atoi(s.data[args[1]])
+		if err != nil && s.data[args[1]] != "" {
+			return "-ERR value is not an integer or out of range\r\n"
+		}
+		s.data[args[1]] = strconv.Itoa(n + 1)
+		return fmt.Sprintf(":%d\r\n", n+1)
+	case "KEYS":
+		return fmt.Sprintf("*1\r\n$%d\r\n%s\r\n", len(args[1]), args[1])
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+func newClient(t *testing.T, s *respServer, pipeline int) *myClient {
+	*redisAddr = s.lis.Addr().String()
+	*redisPipeline = pipeline
+	client, err := clientFactory(gompet.ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.(*myClient)
+}
+
+func TestSplitArgs(t *testing.T) {
+	got, err := splitArgs(` SET key:1  "two words\n" x`)
+	if err != nil || !reflect.DeepEqual(got, []string{"SET", "key:1", "two words\n", "x"}) {
+		t.Errorf("splitArgs = %q, %v", got, err)
+	}
+	if _, err = splitArgs(`SET "unterminated`); err == nil {
+		t.Error("splitArgs accepted unterminated quote")
+	}
+}
+
+func TestReplyTypes(t *testing.T) {
+	s := startServer(t)
+	defer s.lis.Close()
+	c := newClient(t, s, 1)
+	defer c.Term()
+
+	cmds := []string{"PING", "SET k v", "GET k", "GET missing", "INCR n", "INCR k", "KEYS *", "FOO"}
+	want := []string{"PONG", "OK", "bulk", "nil", "integer", "error ERR", "array", "error ERR"}
+	for i, cmd := range cmds {
+		res := c.RunCommand(&gompet.ClientInput{Cmd: cmd})
+		if res.Err != nil || res.Res != want[i] {
+			t.Errorf("%s: got '%s' %v, want '%s'", cmd, res.Res, res.Err, want[i])
+		}
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	s := startServer(t)
+	defer s.lis.Close()
+	c := newClient(t, s, 3)
+	defer c.Term()
+
+	var results []*gompet.ClientResult
+	for _, cmd := range []string{"SET a 1", "INCR a", "GET a", "GET b"} {
+		results = append(results, c.RunCommand(&gompet.ClientInput{Cmd: cmd}))
+	}
+	if results[0] != nil || results[1] != nil || results[3] != nil {
+		t.Fatalf("pipelined results returned early: %v", results)
+	}
+	res := results[2]
+	if len(res.Extra) != 2 || res.Extra[0].Res != "OK" || res.Extra[1].Res != "integer" || res.Res != "bulk" {
+		t.Errorf("pipeline results = %v %v", res, res.Extra)
+	}
+	if res.Conns != 1 {
+		t.Errorf("Conns = %d", res.Conns)
+	}
+	res = c.Flush()
+	if res == nil || res.Res != "nil" || len(res.Extra) != 0 {
+		t.Errorf("Flush = %v", res)
+	}
+	if c.Flush() != nil {
+		t.Error("Flush without pending commands returned a result")
+	}
+}
@@ -0,0 +1,348 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jkarjala/gompet"
+	"github.com/lib/pq"
+)
+
+func TestExpandSQL(t *testing.T) {
+	temp := gompet.Parse("SELECT * FROM t WHERE a=$1 AND b=$2 OR c=$1")
+	args := []string{"A", "B"}
+	want := []interface{}{"A", "B", "A"}
+
+	query, got := ExpandSQL(temp, args, '$')
+	if query != "SELECT * FROM t WHERE a=$1 AND b=$2 OR c=$3" || !reflect.DeepEqual(got, want) {
+		t.Errorf("postgres style = '%s' %v", query, got)
+	}
+	query, got = ExpandSQL(temp, args, '?')
+	if query != "SELECT * FROM t WHERE a=? AND b=? OR c=?" || !reflect.DeepEqual(got, want) {
+		t.Errorf("mysql style = '%s' %v", query, got)
+	}
+}
+
+func TestReturnsRows(t *testing.T) {
+	rows := []string{
+		"SELECT 1", "select * from t", "  \n\tSelect 1", "(SELECT 1) UNION (SELECT 2)",
+		"WITH x AS (SELECT 1) SELECT * FROM x", "SHOW TABLES", "EXPLAIN SELECT 1",
+		"-- comment\nSELECT 1", "/* hint */ SELECT 1", "VALUES (1), (2)",
+		"INSERT INTO t VALUES (1) RETURNING id", "delete from t\nreturning *", "INSERT INTO t VALUES ('a') RETURNING id",
+	}
+	for _, q := range rows {
+		if !returnsRows(q) {
+			t.Errorf("returnsRows(%q) = false", q)
+		}
+	}
+	noRows := []string{
+		"", "BEGIN", "COMMIT", "INSERT INTO t VALUES (1)", "UPDATE t SET a=1",
+		"CREATE TABLE selected (returning_customer INTEGER)", "-- SELECT 1", "/* SELECT 1",
+		"SELECTIVE", "-- comment\nDELETE FROM t", "INSERT INTO t VALUES ('returning')",
+		"UPDATE t SET a=1 -- returning\n", "DELETE FROM t /* RETURNING * */", `UPDATE t SET "returning"=1`,
+	}
+	for _, q := range noRows {
+		if returnsRows(q) {
+			t.Errorf("returnsRows(%q) = true", q)
+		}
+	}
+}
+
+// newSQLiteClient creates a client for the SQLite database file with the command template
+func newSQLiteClient(t *testing.T, dbFile string, template string) gompet.Client {
+	*sqlDriver = "sqlite"
+	*sqlURL = dbFile
+	client, err := clientFactory(gompet.ClientConfig{Template: gompet.Parse(template)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func run(t *testing.T, c gompet.Client, in *gompet.ClientInput, want string) {
+	t.Helper()
+	res := c.RunCommand(in)
+	if res.Err != nil || res.Res != want {
+		t.Errorf("%v: got '%s' %v, want '%s'", in, res.Res, res.Err, want)
+	}
+}
+
+func TestSQLite(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test.db")
+	c := newSQLiteClient(t, dbFile, "")
+	run(t, c, &gompet.ClientInput{Cmd: "CREATE TABLE words (num INTEGER, word TEXT)"}, "0 rows")
+	c.Term()
+
+	c = newSQLiteClient(t, dbFile, "INSERT INTO words VALUES ($1, $2)")
+	run(t, c, &gompet.ClientInput{Args: []string{"1", "one"}}, "1 rows")
+	run(t, c, &gompet.ClientInput{Args: []string{"2", "two"}}, "1 rows")
+	c.Term()
+
+	c = newSQLiteClient(t, dbFile, "SELECT word FROM words WHERE num <= $1")
+	run(t, c, &gompet.ClientInput{Args: []string{"1"}}, "1 rows")
+	run(t, c, &gompet.ClientInput{Args: []string{"2"}}, "2 rows")
+	c.Term()
+
+	c = newSQLiteClient(t, dbFile, "")
+	run(t, c, &gompet.ClientInput{Cmd: "INSERT INTO words VALUES (3, 'three'), (4, 'four') RETURNING num"}, "2 rows")
+	run(t, c, &gompet.ClientInput{Cmd: "  WITH w AS (SELECT * FROM words) SELECT * FROM w"}, "4 rows")
+	run(t, c, &gompet.ClientInput{Cmd: "-- all\nDELETE FROM words"}, "4 rows")
+	c.Term()
+}
+
+func TestPreparedSharedPool(t *testing.T) {
+	*sqlPrepare = true
+	*sqlSharedPool = true
+	*sqlMaxOpen = 1
+	defer func() {
+		*sqlPrepare = false
+		*sqlSharedPool = false
+		*sqlMaxOpen = 0
+		sharedDB.Close()
+		sharedDB = nil
+	}()
+
+	dbFile := filepath.Join(t.TempDir(), "test.db")
+	c1 := newSQLiteClient(t, dbFile, "")
+	run(t, c1, &gompet.ClientInput{Cmd: "CREATE TABLE words (num INTEGER, word TEXT)"}, "0 rows")
+	c2 := newSQLiteClient(t, dbFile, "INSERT INTO words VALUES ($1, $2)")
+	if c1.(*myClient).db != c2.(*myClient).db {
+		t.Error("clients do not share the pool")
+	}
+	run(t, c2, &gompet.ClientInput{Args: []string{"1", "one"}}, "1 rows")
+	run(t, c2, &gompet.ClientInput{Args: []string{"2", "two"}}, "1 rows")
+	if n := len(c2.(*myClient).stmts); n != 1 {
+		t.Errorf("%d statements prepared, want 1", n)
+	}
+	run(t, c1, &gompet.ClientInput{Cmd: "SELECT * FROM words"}, "2 rows")
+	c1.Term()
+	c2.Term()
+	if err := sharedDB.Ping(); err != nil {
+		t.Errorf("shared pool closed by Term: %v", err)
+	}
+}
+
+func TestPreparedLRU(t *testing.T) {
+	*sqlPrepare = true
+	*sqlPrepareMax = 2
+	defer func() {
+		*sqlPrepare = false
+		*sqlPrepareMax = 100
+	}()
+
+	c := newSQLiteClient(t, filepath.Join(t.TempDir(), "test.db"), "")
+	defer c.Term()
+	for _, q := range []string{"SELECT 1", "SELECT 2", "SELECT 1", "SELECT 3", "SELECT 2"} {
+		run(t, c, &gompet.ClientInput{Cmd: q}, "1 rows")
+	}
+	var got []string
+	for e := c.(*myClient).stmtLRU.Front(); e != nil; e = e.Next() {
+		got = append(got, e.Value.(*preparedStmt).query)
+	}
+	if !reflect.DeepEqual(got, []string{"SELECT 2", "SELECT 3"}) || len(c.(*myClient).stmts) != 2 {
+		t.Errorf("prepared statements = %q", got)
+	}
+}
+
+func TestReadRows(t *testing.T) {
+	c := newSQLiteClient(t, filepath.Join(t.TempDir(), "test.db"), "")
+	defer c.Term()
+	db := c.(*myClient).db
+	for _, keep := range []bool{false, true} {
+		rows, err := db.Query("VALUES (1, 'a'), (2, NULL)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := crc32.NewIEEE()
+		res, count, err := ReadRows(rows, sum, keep)
+		want := [][]string(nil)
+		if keep {
+			want = [][]string{{"1", "a"}, {"2", "null"}}
+		}
+		if err != nil || count != 2 || !reflect.DeepEqual(res, want) || sum.Sum32() != crc32.ChecksumIEEE([]byte("1\ta\n2\tnull\n")) {
+			t.Errorf("keep %v: %q %d %v", keep, res, count, err)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	got := splitStatements("SELECT ';' FROM t; -- a; b\nUPDATE t SET \"x;\"=1 /* ; */;\n\n ; INSERT INTO t VALUES (1)")
+	want := []string{"SELECT ';' FROM t", "-- a; b\nUPDATE t SET \"x;\"=1 /* ; */", "INSERT INTO t VALUES (1)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitStatements = %q", got)
+	}
+	for _, s := range []string{"a$1b$2", "$1", "x$2", "no vars", "$ and $1$2"} {
+		if got := templateString(gompet.Parse(s)); got != s {
+			t.Errorf("templateString(%q) = %q", s, got)
+		}
+	}
+}
+
+func TestSplitCommands(t *testing.T) {
+	input := "INSERT INTO t VALUES ('a;b'); -- c;\nSELECT /* ; */ 1;\nSELECT 2"
+	scanner := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(input)))
+	scanner.Split((&myClient{}).SplitCommands(";"))
+	var got []string
+	for scanner.Scan() {
+		got = append(got, strings.TrimSpace(scanner.Text()))
+	}
+	want := []string{"INSERT INTO t VALUES ('a;b')", "-- c;\nSELECT /* ; */ 1", "SELECT 2"}
+	if !reflect.DeepEqual(got, want) || scanner.Err() != nil {
+		t.Errorf("SplitCommands = %q, %v", got, scanner.Err())
+	}
+}
+
+func TestTxConflict(t *testing.T) {
+	errs := map[error]string{
+		&pq.Error{Code: "40P01", Message: "deadlock detected"}:                     "Deadlock",
+		&pq.Error{Code: "40001", Message: "could not serialize access"}:            "Serialization failure",
+		&pq.Error{Code: "23505", Message: "duplicate key"}:                         "",
+		&mysql.MySQLError{Number: 1213, Message: "Deadlock found"}:                 "Deadlock",
+		fmt.Errorf("exec: %w", errors.New("database is locked (5) (SQLITE_BUSY)")): "Database locked",
+	}
+	for err, want := range errs {
+		if got := txConflict(err); got != want {
+			t.Errorf("txConflict(%v) = '%s', want '%s'", err, got, want)
+		}
+	}
+	err := conflictError(&pq.Error{Code: "40001", Message: "could not serialize access"})
+	var pqErr *pq.Error
+	if !strings.HasPrefix(err.Error(), "Serialization failure: ") || !errors.As(err, &pqErr) {
+		t.Errorf("conflictError = %v", err)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	errs := map[error]string{
+		&pq.Error{Code: "40P01", Message: "deadlock detected"}:                  "Deadlock",
+		&pq.Error{Code: "23505", Message: "duplicate key value"}:                "SQL constraint",
+		&mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key"}: "SQL constraint",
+		errors.New("UNIQUE constraint failed: t.a"):                             "",
+	}
+	var c myClient
+	for err, want := range errs {
+		if got := c.ClassifyError(err); got != want {
+			t.Errorf("ClassifyError(%v) = '%s', want '%s'", err, got, want)
+		}
+	}
+}
+
+func TestTxBatch(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test.db")
+	c := newSQLiteClient(t, dbFile, "")
+	run(t, c, &gompet.ClientInput{Cmd: "CREATE TABLE words (num INTEGER, word TEXT)"}, "0 rows")
+	c.Term()
+
+	*sqlTx = 2
+	defer func() { *sqlTx = 0 }()
+	c = newSQLiteClient(t, dbFile, "INSERT INTO words VALUES ($1, $2)")
+	res := c.RunCommand(&gompet.ClientInput{Args: []string{"1", "one"}})
+	if res.Res != "1 rows" || len(res.Extra) != 0 {
+		t.Errorf("first = %v %v", res, res.Extra)
+	}
+	res = c.RunCommand(&gompet.ClientInput{Args: []string{"2", "two"}})
+	if len(res.Extra) != 1 || res.Extra[0].Res != "tx commit" || res.Extra[0].Err != nil {
+		t.Errorf("second = %v %v", res, res.Extra)
+	}
+	c.RunCommand(&gompet.ClientInput{Args: []string{"3", "three"}})
+	res = c.(gompet.Flusher).Flush()
+	if res == nil || res.Res != "tx commit" {
+		t.Errorf("Flush = %v", res)
+	}
+	c.Term()
+
+	*sqlTx = 0
+	c = newSQLiteClient(t, dbFile, "")
+	run(t, c, &gompet.ClientInput{Cmd: "SELECT * FROM words"}, "3 rows")
+	c.Term()
+}
+
+func TestTxBlock(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test.db")
+	c := newSQLiteClient(t, dbFile, "")
+	run(t, c, &gompet.ClientInput{Cmd: "CREATE TABLE words (num INTEGER, word TEXT)"}, "0 rows")
+	c.Term()
+
+	*sqlTxBlock = true
+	defer func() { *sqlTxBlock = false }()
+	c = newSQLiteClient(t, dbFile, "INSERT INTO words VALUES ($1, $2);\nSELECT * FROM words WHERE num = $1")
+	res := c.RunCommand(&gompet.ClientInput{Args: []string{"1", "one"}})
+	if res.Res != "tx commit" || res.Err != nil || len(res.Extra) != 2 ||
+		res.Extra[0].Res != "1 rows" || res.Extra[1].Res != "1 rows" {
+		t.Errorf("block = %v %v", res, res.Extra)
+	}
+	c.Term()
+
+	c = newSQLiteClient(t, dbFile, "")
+	res = c.RunCommand(&gompet.ClientInput{Cmd: "INSERT INTO words VALUES (2, 'two'); SELECT * FROM missing"})
+	if res.Res != "tx rollback" || len(res.Extra) != 2 || res.Extra[1].Err == nil {
+		t.Errorf("failed block = %v %v", res, res.Extra)
+	}
+	res = c.RunCommand(&gompet.ClientInput{Cmd: "SELECT * FROM words"})
+	if res.Res != "tx commit" || res.Extra[0].Res != "1 rows" {
+		t.Errorf("rolled back insert visible: %v %v", res, res.Extra)
+	}
+	c.Term()
+}
+
+func TestRowsLabel(t *testing.T) {
+	var c myClient
+	var err error
+	if _, err = parseBuckets("1,0"); err == nil {
+		t.Error("parseBuckets accepted descending limits")
+	}
+	if c.buckets, err = parseBuckets("0,1,10"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]string{0: "0 rows", 1: "1 rows", 2: "2-10 rows", 10: "2-10 rows", 11: ">10 rows"}
+	for count, label := range want {
+		if got := c.rowsLabel(count); got != label {
+			t.Errorf("rowsLabel(%d) = '%s', want '%s'", count, got, label)
+		}
+	}
+}
+
+func TestExpectedResults(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "test.db")
+	c := newSQLiteClient(t, dbFile, "")
+	run(t, c, &gompet.ClientInput{Cmd: "CREATE TABLE words (num INTEGER, word TEXT)"}, "0 rows")
+	run(t, c, &gompet.ClientInput{Cmd: "INSERT INTO words VALUES (1, 'one'), (2, NULL)"}, "2 rows")
+	c.Term()
+
+	*sqlExpectRows = 2
+	*sqlExpectChecksum = 3
+	defer func() {
+		*sqlExpectRows = 0
+		*sqlExpectChecksum = 0
+	}()
+	c = newSQLiteClient(t, dbFile, "SELECT num, word FROM words WHERE num <= $1")
+	one := fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte("1\tone\n")))
+	both := fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte("1\tone\n2\tnull\n")))
+	inputs := map[string][]string{
+		"":                          {"1", "1", one},
+		"Row count mismatch":        {"2", "1", both},
+		"Checksum mismatch":         {"2", "2", one},
+		"Missing expected checksum": {"2", "2"},
+	}
+	for want, args := range inputs {
+		res := c.RunCommand(&gompet.ClientInput{Args: args})
+		if (want == "" && res.Err != nil) || (want != "" && (res.Err == nil || res.Err.Error() != want)) {
+			t.Errorf("%v: got %v, want '%s'", args, res.Err, want)
+		}
+		if len(res.Timings) != 2 || res.Timings[0].Name != "Execute" || res.Timings[1].Name != "Fetch" {
+			t.Errorf("Timings = %v", res.Timings)
+		}
+	}
+	c.Term()
+}
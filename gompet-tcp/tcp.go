@@ -0,0 +1,200 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+// TCP and UDP Client for simple line protocols
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/jkarjala/gompet"
+)
+
+// Long options for the testers, short ones used by the main library
+var tcpAddr = flag.String("addr", "", "Server address, e.g. localhost:4202")
+var tcpNetwork = flag.String("network", "tcp", "Network, 'tcp' or 'udp'")
+var tcpPerCommand = flag.Bool("per-command", false, "Open a new connection for each command")
+var tcpDelimiter = flag.String("delimiter", `\n`, "Response delimiter with escapes, empty reads until -idle-timeout")
+var tcpLengthPrefix = flag.Int("length-prefix", 0, "Response starts with 1, 2 or 4 byte big-endian length, overrides -delimiter")
+var tcpIdleTimeout = flag.Duration("idle-timeout", 100*time.Millisecond, "Response is complete when no data for given duration, if no delimiter")
+var tcpTimeout = flag.Int("timeout", 10, "Connect and response timeout in seconds")
+
+func main() {
+	log.Println("TCP tester started")
+	gompet.Run(clientFactory)
+}
+
+type myClient struct {
+	config    gompet.ClientConfig
+	delimiter []byte
+	conn      net.Conn
+	reader    *bufio.Reader
+	buf       []byte // UDP datagram buffer
+}
+
+func clientFactory(config gompet.ClientConfig) (gompet.Client, error) {
+	log.Println(config.ID, "tcp init")
+	if *tcpAddr == "" {
+		return nil, errors.New("Missing --addr")
+	}
+	if *tcpNetwork != "tcp" && *tcpNetwork != "udp" {
+		return nil, errors.New("Unsupported network, use tcp or udp")
+	}
+	switch *tcpLengthPrefix {
+	case 0, 1, 2, 4:
+	default:
+		return nil, errors.New("Length prefix must be 1, 2 or 4 bytes")
+	}
+	delimiter, err := unescape(*tcpDelimiter)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid delimiter: %v", err)
+	}
+	var client = myClient{config: config, delimiter: []byte(delimiter)}
+	if *tcpNetwork == "udp" {
+		client.buf = make([]byte, 65536)
+	}
+	return &client, nil
+}
+
+// unescape replaces Go escape sequences like \n, \t and \x00 in the string
+func unescape(s string) (string, error) {
+	var b bytes.Buffer
+	for len(s) > 0 {
+		r, multibyte, tail, err := strconv.UnquoteChar(s, 0)
+		if err != nil {
+			return "", err
+		}
+		if multibyte {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte(byte(r)) // e.g. \xff is a byte, not a rune
+		}
+		s = tail
+	}
+	return b.String(), nil
+}
+
+func (c *myClient) RunCommand(in *gompet.ClientInput) *gompet.ClientResult {
+	cmd := in.Cmd
+	if c.config.Template != nil {
+		cmd = c.config.Template.Expand(in.Args)
+	}
+	message, err := unescape(cmd)
+	if err != nil {
+		return &gompet.ClientResult{Err: fmt.Errorf("Invalid escape in '%s': %v", cmd, err)}
+	}
+
+	var conns int
+	var timings []gompet.Timing
+	var start = time.Now()
+	if c.conn == nil {
+		c.conn, err = net.DialTimeout(*tcpNetwork, *tcpAddr, time.Duration(*tcpTimeout)*time.Second)
+		if err != nil {
+			return &gompet.ClientResult{Err: err, Time: time.Since(start).Seconds()}
+		}
+		c.reader = bufio.NewReader(c.conn)
+		conns = 1
+		timings = []gompet.Timing{{Name: "Connect", Time: time.Since(start).Seconds()}}
+		start = time.Now()
+	}
+
+	c.conn.SetDeadline(start.Add(time.Duration(*tcpTimeout) * time.Second))
+	var response []byte
+	var end time.Time
+	_, err = c.conn.Write([]byte(message))
+	if err == nil {
+		response, end, err = c.read()
+	}
+	elapsed := time.Since(start).Seconds()
+	if err == nil {
+		elapsed = end.Sub(start).Seconds() // excludes the idle timeout of unframed responses
+	}
+	if err != nil || *tcpPerCommand {
+		c.conn.Close() // the rest of a failed response would be read by the next command
+		c.conn = nil
+	}
+	if err != nil {
+		return &gompet.ClientResult{Err: err, Time: elapsed, Conns: conns, Timings: timings}
+	}
+
+	var res = "(empty)"
+	if fields := bytes.Fields(response); len(fields) > 0 {
+		res = string(fields[0])
+		if len(res) > 32 {
+			res = res[:32]
+		}
+	}
+	if c.config.Verbose {
+		log.Printf("%d tcp %q: %q", c.config.ID, message, response)
+	}
+	return &gompet.ClientResult{Res: res, Time: elapsed, Conns: conns, Timings: timings,
+		ReqBytes: int64(len(message)), RespBytes: int64(len(response))}
+}
+
+// read reads one response according to the framing options, returns the time the last data was received
+func (c *myClient) read() ([]byte, time.Time, error) {
+	if c.buf != nil { // one datagram is one response
+		n, err := c.conn.Read(c.buf)
+		return c.buf[:n], time.Now(), err
+	}
+	if *tcpLengthPrefix > 0 {
+		header := make([]byte, *tcpLengthPrefix)
+		if _, err := io.ReadFull(c.reader, header); err != nil {
+			return nil, time.Time{}, err
+		}
+		var length int
+		for _, b := range header {
+			length = length<<8 | int(b)
+		}
+		response := make([]byte, length)
+		_, err := io.ReadFull(c.reader, response)
+		return response, time.Now(), err
+	}
+	if len(c.delimiter) > 0 {
+		last := c.delimiter[len(c.delimiter)-1]
+		var response []byte
+		for !bytes.HasSuffix(response, c.delimiter) {
+			chunk, err := c.reader.ReadBytes(last)
+			response = append(response, chunk...)
+			if err != nil {
+				return response, time.Time{}, err
+			}
+		}
+		return response[:len(response)-len(c.delimiter)], time.Now(), nil
+	}
+
+	// no framing, read until the server stops sending
+	var response []byte
+	var end time.Time
+	chunk := make([]byte, 4096)
+	for {
+		n, err := c.reader.Read(chunk)
+		if n > 0 {
+			end = time.Now()
+			response = append(response, chunk[:n]...)
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() && len(response) > 0 {
+			return response, end, nil
+		}
+		if err != nil {
+			return response, end, err
+		}
+		c.conn.SetReadDeadline(time.Now().Add(*tcpIdleTimeout))
+	}
+}
+
+func (c *myClient) Term() {
+	log.Println(c.config.ID, "tcp term")
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
@@ -0,0 +1,97 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jkarjala/gompet"
+)
+
+// startEcho starts an in-process TCP server which writes back what it reads,
+// the second half 20ms later to test unframed reads
+func startEcho(t *testing.T) net.Listener {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 4096)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						return
+					}
+					conn.Write(buf[:n/2])
+					time.Sleep(20 * time.Millisecond)
+					conn.Write(buf[n/2 : n])
+				}
+			}()
+		}
+	}()
+	*tcpAddr = lis.Addr().String()
+	return lis
+}
+
+func newClient(t *testing.T, delimiter string, lengthPrefix int) *myClient {
+	*tcpDelimiter = delimiter
+	*tcpLengthPrefix = lengthPrefix
+	client, err := clientFactory(gompet.ClientConfig{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.(*myClient)
+}
+
+func TestFramed(t *testing.T) {
+	lis := startEcho(t)
+	defer lis.Close()
+	defer func() { *tcpDelimiter = `\n`; *tcpLengthPrefix = 0 }()
+
+	c := newClient(t, `\r\n`, 0)
+	defer c.Term()
+	res := c.RunCommand(&gompet.ClientInput{Cmd: `+OK ready\r\n`})
+	if res.Err != nil || res.Res != "+OK" || res.RespBytes != 9 || res.Conns != 1 {
+		t.Errorf("delimiter: %+v", res)
+	}
+	res = c.RunCommand(&gompet.ClientInput{Cmd: `PONG\r\n`})
+	if res.Err != nil || res.Res != "PONG" || res.Conns != 0 {
+		t.Errorf("reused: %+v", res)
+	}
+
+	c2 := newClient(t, `\n`, 2)
+	defer c2.Term()
+	res = c2.RunCommand(&gompet.ClientInput{Cmd: `\x00\x05hello`})
+	if res.Err != nil || res.Res != "hello" || res.RespBytes != 5 {
+		t.Errorf("length prefix: %+v", res)
+	}
+}
+
+func TestUnframed(t *testing.T) {
+	lis := startEcho(t)
+	defer lis.Close()
+	*tcpIdleTimeout = 200 * time.Millisecond
+	defer func() { *tcpDelimiter = `\n`; *tcpIdleTimeout = 100 * time.Millisecond }()
+
+	c := newClient(t, "", 0)
+	defer c.Term()
+	for i := 0; i < 2; i++ {
+		res := c.RunCommand(&gompet.ClientInput{Cmd: "hello world"})
+		if res.Err != nil || res.Res != "hello" || res.RespBytes != 11 {
+			t.Errorf("unframed: %+v", res)
+		}
+		if res.Time < 0.02 || res.Time >= 0.2 {
+			t.Errorf("time %f should include the second write but not the idle timeout", res.Time)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+// WebSocket Client, each client keeps a persistent connection and sends commands as messages
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/jkarjala/gompet"
+)
+
+// Long options for the testers, short ones used by the main library
+var wsURL = flag.String("url", "", "WebSocket URL, e.g. ws://localhost:4200/ws")
+var wsAuth = flag.String("auth", "", "HTTP Authorization header for the handshake")
+var wsBinary = flag.Bool("binary", false, "Send binary instead of text messages")
+var wsCorrelation = flag.String("correlation-id", "", "Regexp with a group to match the reply ID to the message ID, next message if empty")
+var wsConnect = flag.Bool("connect-only", false, "Measure connection setup, open and close a connection for each command")
+var wsTimeout = flag.Int("timeout", 10, "Handshake and reply timeout in seconds")
+
+var tlsOptions = gompet.TLSFlags()
+
+func main() {
+	log.Println("WebSocket tester started")
+	gompet.Run(clientFactory)
+}
+
+var messageTypes = map[int]string{
+	websocket.TextMessage:   "text",
+	websocket.BinaryMessage: "binary",
+}
+
+type myClient struct {
+	config      gompet.ClientConfig
+	dialer      *websocket.Dialer
+	header      http.Header
+	correlation *regexp.Regexp
+	conn        *websocket.Conn
+}
+
+func clientFactory(config gompet.ClientConfig) (gompet.Client, error) {
+	log.Println(config.ID, "websocket init")
+	if *wsURL == "" {
+		return nil, errors.New("Missing --url")
+	}
+	tlsConfig, err := gompet.NewTLSConfig(tlsOptions)
+	if err != nil {
+		return nil, err
+	}
+	var correlation *regexp.Regexp
+	if *wsCorrelation != "" {
+		correlation, err = regexp.Compile(*wsCorrelation)
+		if err != nil {
+			return nil, err
+		}
+		if correlation.NumSubexp() != 1 {
+			return nil, errors.New("Correlation ID regexp must have exactly one group")
+		}
+	}
+	dialer := &websocket.Dialer{
+		TLSClientConfig:  tlsConfig,
+		HandshakeTimeout: time.Duration(*wsTimeout) * time.Second,
+	}
+	header := make(http.Header)
+	if *wsAuth != "" {
+		header.Add("Authorization", *wsAuth)
+	}
+	var client = myClient{config, dialer, header, correlation, nil}
+	return &client, nil
+}
+
+// connect opens the connection and returns the handshake time
+func (c *myClient) connect() (float64, error) {
+	var start = time.Now()
+	conn, resp, err := c.dialer.Dial(*wsURL, c.header)
+	elapsed := time.Since(start).Seconds()
+	if err != nil {
+		if resp != nil {
+			return elapsed, fmt.Errorf("%v: %s", err, resp.Status)
+		}
+		return elapsed, err
+	}
+	c.conn = conn
+	return elapsed, nil
+}
+
+func (c *myClient) RunCommand(in *gompet.ClientInput) *gompet.ClientResult {
+	cmd := in.Cmd
+	if c.config.Template != nil {
+		cmd = c.config.Template.Expand(in.Args)
+	}
+
+	if *wsConnect {
+		elapsed, err := c.connect()
+		if err != nil {
+			return &gompet.ClientResult{Err: err, Time: elapsed}
+		}
+		c.conn.Close()
+		c.conn = nil
+		return &gompet.ClientResult{Res: "connected", Time: elapsed, Conns: 1}
+	}
+
+	var conns int
+	var timings []gompet.Timing
+	if c.conn == nil {
+		elapsed, err := c.connect()
+		if err != nil {
+			return &gompet.ClientResult{Err: err, Time: elapsed}
+		}
+		conns = 1
+		timings = []gompet.Timing{{Name: "Handshake", Time: elapsed}}
+	}
+
+	var id string
+	if c.correlation != nil {
+		m := c.correlation.FindStringSubmatch(cmd)
+		if m == nil {
+			return &gompet.ClientResult{Err: fmt.Errorf("No correlation ID in message '%s'", cmd)}
+		}
+		id = m[1]
+	}
+	messageType := websocket.TextMessage
+	if *wsBinary {
+		messageType = websocket.BinaryMessage
+	}
+
+	var start = time.Now()
+	err := c.conn.WriteMessage(messageType, []byte(cmd))
+	if err != nil {
+		return c.fail(err, start, conns, timings)
+	}
+	c.conn.SetReadDeadline(start.Add(time.Duration(*wsTimeout) * time.Second))
+	var reply []byte
+	var replyType, skipped int
+	for {
+		replyType, reply, err = c.conn.ReadMessage()
+		if err != nil {
+			return c.fail(err, start, conns, timings)
+		}
+		if c.correlation == nil {
+			break
+		}
+		m := c.correlation.FindSubmatch(reply)
+		if m != nil && string(m[1]) == id {
+			break
+		}
+		skipped++ // server push or a reply to some other message
+	}
+	elapsed := time.Since(start).Seconds()
+	var res = messageTypes[replyType] + " reply"
+	if c.config.Verbose {
+		log.Printf("%d websocket %s: %s '%s' (skipped %d)", c.config.ID, cmd, res, reply, skipped)
+	}
+	return &gompet.ClientResult{Res: res, Time: elapsed, Conns: conns, Timings: timings,
+		ReqBytes: int64(len(cmd)), RespBytes: int64(len(reply))}
+}
+
+// fail closes the broken connection, next command will reconnect
+func (c *myClient) fail(err error, start time.Time, conns int, timings []gompet.Timing) *gompet.ClientResult {
+	c.conn.Close()
+	c.conn = nil
+	return &gompet.ClientResult{Err: err, Time: time.Since(start).Seconds(), Conns: conns, Timings: timings}
+}
+
+func (c *myClient) Term() {
+	log.Println(c.config.ID, "websocket term")
+	if c.conn != nil {
+		c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		c.conn.Close()
+	}
+}
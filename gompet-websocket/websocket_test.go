@@ -0,0 +1,110 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fasthttp/websocket"
+	"github.com/jkarjala/gompet"
+)
+
+// echoServer echoes the messages with their type, pushing a notice first if the message says so
+type echoServer struct {
+	*httptest.Server
+	conns int32
+}
+
+func startServer(t *testing.T) *echoServer {
+	s := &echoServer{}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.conns, 1) // before the client sees the handshake response
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if strings.Contains(string(message), "push") {
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"other"}`))
+			}
+			conn.WriteMessage(messageType, message)
+		}
+	}))
+	*wsURL = "ws" + strings.TrimPrefix(s.URL, "http")
+	return s
+}
+
+func newClient(t *testing.T) *myClient {
+	client, err := clientFactory(gompet.ClientConfig{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.(*myClient)
+}
+
+func TestEcho(t *testing.T) {
+	s := startServer(t)
+	defer s.Close()
+	c := newClient(t)
+	defer c.Term()
+
+	res := c.RunCommand(&gompet.ClientInput{Cmd: "hello"})
+	if res.Err != nil || res.Res != "text reply" || res.Conns != 1 || len(res.Timings) != 1 || res.RespBytes != 5 {
+		t.Errorf("first: %+v", res)
+	}
+	*wsBinary = true
+	defer func() { *wsBinary = false }()
+	res = c.RunCommand(&gompet.ClientInput{Cmd: "world"})
+	if res.Err != nil || res.Res != "binary reply" || res.Conns != 0 || len(res.Timings) != 0 {
+		t.Errorf("reused: %+v", res)
+	}
+	if n := atomic.LoadInt32(&s.conns); n != 1 {
+		t.Errorf("server got %d connections, want 1", n)
+	}
+}
+
+func TestCorrelation(t *testing.T) {
+	s := startServer(t)
+	defer s.Close()
+	*wsCorrelation = `"id":"(\w+)"`
+	defer func() { *wsCorrelation = "" }()
+	c := newClient(t)
+	defer c.Term()
+
+	res := c.RunCommand(&gompet.ClientInput{Cmd: `{"id":"1","push":true}`})
+	if res.Err != nil || res.Res != "text reply" || res.RespBytes != int64(len(`{"id":"1","push":true}`)) {
+		t.Errorf("correlated: %+v", res)
+	}
+	if res = c.RunCommand(&gompet.ClientInput{Cmd: "no id"}); res.Err == nil {
+		t.Errorf("missing id: %+v", res)
+	}
+}
+
+func TestConnectOnly(t *testing.T) {
+	s := startServer(t)
+	defer s.Close()
+	*wsConnect = true
+	defer func() { *wsConnect = false }()
+	c := newClient(t)
+	defer c.Term()
+
+	for i := 0; i < 2; i++ {
+		if res := c.RunCommand(&gompet.ClientInput{Cmd: "x"}); res.Err != nil || res.Res != "connected" || res.Conns != 1 {
+			t.Errorf("connect: %+v", res)
+		}
+	}
+	if n := atomic.LoadInt32(&s.conns); n != 2 {
+		t.Errorf("server got %d connections, want 2", n)
+	}
+}
@@ -8,26 +8,33 @@ package gompet
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/csv"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
-	_ "net/http" // for profiler
-	_ "net/http/pprof"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var filename = flag.String("f", "", "Input file name, stdin if '-'")
 var cmdTemplate = flag.String("t", "", "Command template, $1-$9 refers to tab-separated columns in input")
+var separator = flag.String("s", "", "Command separator instead of newline, e.g. ';' for multi-line SQL statements")
 var progress = flag.Bool("P", false, "Report progress once a second")
+var dashboardFlag = flag.Bool("dashboard", false, "Show live dashboard in the terminal, or a row per second if not a terminal")
 var profile = flag.Bool("pprof", false, "Enable pprof web server")
+var control = flag.Bool("control", false, "Enable control API in the pprof web server port")
+var serverStatsURL = flag.String("server-stats", "", "Server stats URL to reset at start and show at end, e.g. http://localhost:4200/admin/stats")
 
 var repeat = flag.Int("r", 1, "Repeat the input N times, does not work with stdin")
 var duration = flag.Duration("d", 0, "Test until given duration elapses, e.g 5m for 5 minutes")
@@ -37,6 +44,22 @@ var rateLimit = flag.Int("R", 0, "Rate limit each client to N queries/sec (accur
 var periodicStats = flag.Int("S", 0, "Show and reset percentiles every N seconds, 0 shows at end")
 
 var numClients = flag.Int("c", 1, "Number of parallel clients executing commands")
+var clientSchedule = flag.String("clients-schedule", "", "Change the number of clients at given times, e.g. '30s:10,1m:20,2m:5'")
+
+var retries = flag.Int("retry", 0, "Retry failed commands up to N times")
+var retryOn = flag.String("retry-on", "", "Retry only the commands whose error or result matches the regexp, e.g. '^5|reset'")
+var retryBackoff = flag.Duration("retry-backoff", 100*time.Millisecond, "Random backoff before the first retry, doubled for each retry")
+var retryMaxBackoff = flag.Duration("retry-max-backoff", 5*time.Second, "Max random backoff before a retry")
+
+var abortErrors = flag.Float64("abort-errors", 0, "Abort when the share of errors over -abort-window exceeds this, e.g. 0.5")
+var abortWindow = flag.Duration("abort-window", 10*time.Second, "Sliding window for -abort-errors")
+var abortConsecutive = flag.Int("abort-consecutive", 0, "Abort after N consecutive errors")
+var abortPause = flag.Duration("abort-pause", 0, "Pause for given duration instead of aborting, then resume")
+var abortOn = flag.String("abort-on", "", "Count also the results matching the regexp as errors for aborting, e.g. '^5'")
+
+var searchSpec = flag.String("search", "", "Search max throughput by stepping 'rate' (total cmds/sec) or 'clients' from start by step, e.g. rate:100:100[:max]")
+var searchHold = flag.Duration("search-hold", 10*time.Second, "Duration of each search step")
+var searchSLO = flag.String("search-slo", "", "Latency limit of the search steps, e.g. p95=50ms")
 
 var verbose = flag.Bool("v", false, "Verbose logging")
 
@@ -44,7 +67,7 @@ var verbose = flag.Bool("v", false, "Verbose logging")
 type ClientConfig struct {
 	ID         int
 	Template   *VarTemplate
-	NumClients int
+	NumClients int // active clients wanted when this one was created
 	Verbose    bool
 }
 
@@ -56,17 +79,47 @@ type ClientInput struct {
 
 // ClientResult is returned from client after processing one input line
 type ClientResult struct {
-	Res  string  // result, count of each separate value is reported
-	Time float64 // execution time in seconds, percentiles are reported
-	Err  error   // error result or nil, count of each error is reported (if any)
+	Res       string          // result, count of each separate value is reported
+	Time      float64         // execution time in seconds, percentiles are reported
+	Err       error           // error result or nil, count of each error is reported (if any)
+	ErrClass  string          // optional error class, errors are counted by class
+	Conns     int             // new connections opened by the command, total is reported
+	ReqBytes  int64           // request body bytes sent, total and rate are reported
+	RespBytes int64           // response body bytes received, total, rate and percentiles are reported
+	Decoded   int64           // response body bytes after decompression, total is reported if set
+	Timings   []Timing        // optional named phases of the command, percentiles are reported per name
+	Extra     []*ClientResult // optional additional results, e.g. redirect hops, counted as separate commands
+	Retries   int             // retries of the command, set by the framework
+	First     *ClientResult   // result of the first attempt if retried, set by the framework
+	TotalTime float64         // execution time including retries and backoffs, set by the framework
+}
+
+// Timing is the duration of one named phase of a command, e.g. DNS lookup
+type Timing struct {
+	Name string  // name of the phase, phases are reported in order of first appearance
+	Time float64 // phase duration in seconds
 }
 
-// Client is the interface the client must implement
+// Client is the interface the client must implement.
+// RunCommand may return nil to defer the result, e.g. when pipelining commands,
+// the deferred results are then returned later in ClientResult.Extra.
 type Client interface {
 	RunCommand(in *ClientInput) *ClientResult
 	Term()
 }
 
+// Flusher is implemented by clients which defer results, Flush is called before Term
+// to execute the remaining commands and return their results
+type Flusher interface {
+	Flush() *ClientResult
+}
+
+// CommandSplitter is implemented by clients which find the end of each -s separated
+// command themselves, e.g. to skip separators inside quotes and comments
+type CommandSplitter interface {
+	SplitCommands(sep string) bufio.SplitFunc
+}
+
 // ClientFactory creates a client instance
 type ClientFactory func(config ClientConfig) (Client, error)
 
@@ -74,33 +127,68 @@ func init() {
 	// Nothing to do now
 }
 
+const maxCommandSize = 64 * 1024 * 1024 // longest -s separated command
+
 var argsInput string
-var clients []Client
 var inputChan = make(chan *ClientInput)
 var outputChan = make(chan *ClientResult)
 var waitGroup sync.WaitGroup
 var stop bool
 var done = make(chan bool)
+var clientInterval int64                              // nanoseconds between the commands of each client, 0 for no rate limit
+var collectorChan = make(chan func(results *Results)) // functions run by the result collector
 
 // Run function executes the commands and reports results
 func Run(clientFactory ClientFactory) {
 	Setup()
-	if *profile {
-		go func() {
-			fmt.Println("Profiler active in http://localhost:4221/debug/pprof")
-			http.ListenAndServe("localhost:4221", nil)
-		}()
+	if *profile || *control {
+		serveWeb()
 	}
 
 	results := Exec(clientFactory)
 	results.Report()
 
+	if results.Aborted != "" {
+		os.Exit(3)
+	}
 	if *profile {
 		fmt.Println("Run ready, ctrl-c to exit")
 		select {} // wait forever
 	}
 }
 
+// serveWeb starts the web server for the profiler and the control API, the process
+// exits if the server fails
+func serveWeb() {
+	mux := http.NewServeMux()
+	if *profile {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	if *control {
+		registerControl(mux)
+	}
+	lis, err := net.Listen("tcp", "localhost:4221")
+	if err != nil {
+		fmt.Println("Cannot start web server:", err)
+		os.Exit(2)
+	}
+	if *profile {
+		fmt.Println("Profiler active in http://localhost:4221/debug/pprof")
+	}
+	if *control {
+		fmt.Println("Control API active in http://localhost:4221/control")
+	}
+	go func() {
+		err := http.Serve(lis, mux)
+		fmt.Println("Web server failed:", err)
+		os.Exit(2)
+	}()
+}
+
 // Setup configures the execution from the command line flags
 func Setup() {
 	flag.Usage = func() {
@@ -127,6 +215,56 @@ func Setup() {
 		}
 		argsInput = strings.Join(flag.Args(), "\n") + "\n"
 	}
+	if *separator != "" && *cmdTemplate != "" {
+		fmt.Println("Cannot use -s with -t template, template input is tab-separated lines")
+		os.Exit(1)
+	}
+	if *retryOn != "" {
+		var err error
+		if retryPattern, err = regexp.Compile(*retryOn); err != nil {
+			fmt.Println("Invalid -retry-on:", err)
+			os.Exit(1)
+		}
+	}
+	if *abortOn != "" {
+		var err error
+		if abortPattern, err = regexp.Compile(*abortOn); err != nil {
+			fmt.Println("Invalid -abort-on:", err)
+			os.Exit(1)
+		}
+	}
+	setClientRate(*rateLimit)
+	if *searchSpec != "" {
+		var err error
+		if search, err = parseSearch(*searchSpec, *searchSLO); err != nil {
+			fmt.Println("Invalid -search:", err)
+			os.Exit(1)
+		}
+		if *delay > 0 {
+			fmt.Println("Cannot use -D with -search, the search ramps up the load")
+			os.Exit(1)
+		}
+		search.setup()
+	}
+	if *clientSchedule != "" {
+		var err error
+		if schedule, err = parseClientSchedule(*clientSchedule); err != nil {
+			fmt.Println("Invalid -clients-schedule:", err)
+			os.Exit(1)
+		}
+		if search != nil {
+			fmt.Println("Cannot use -clients-schedule with -search")
+			os.Exit(1)
+		}
+	}
+	if *abortErrors < 0 || *abortErrors >= 1 {
+		fmt.Println("Invalid -abort-errors, share of errors must be between 0 and 1")
+		os.Exit(1)
+	}
+	if *dashboardFlag && (*progress || *periodicStats > 0) {
+		fmt.Println("Cannot use -dashboard with -P or -S, the dashboard shows both")
+		os.Exit(1)
+	}
 	if *progress && *periodicStats > 0 {
 		fmt.Println("Cannot report progress and periodic percentiles at the same time")
 		os.Exit(1)
@@ -135,7 +273,7 @@ func Setup() {
 
 // Exec executes the commands, global flags must have been set up before this
 func Exec(clientFactory ClientFactory) *Results {
-	sc := make(chan os.Signal)
+	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, os.Interrupt)
 	go func() {
 		<-sc
@@ -148,8 +286,10 @@ func Exec(clientFactory ClientFactory) *Results {
 		}()
 	}()
 
-	if *duration > 0 {
+	if *duration > 0 || search != nil {
 		*repeat = 1 << 30 // should be enough for a very long duration :-)
+	}
+	if *duration > 0 {
 		go func() {
 			time.Sleep(*duration)
 			fmt.Printf("%s elapsed, stopping...   \n", *duration)
@@ -157,13 +297,31 @@ func Exec(clientFactory ClientFactory) *Results {
 		}()
 	}
 
+	if *serverStatsURL != "" {
+		if _, err := FetchServerStats(*serverStatsURL, true); err != nil {
+			fmt.Println("Cannot reset server stats:", err)
+			os.Exit(2)
+		}
+	}
+
 	err := LaunchClients(clientFactory)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(2)
 	}
 	var results = NewResults(*progress, *periodicStats)
+	results.Retry = *retries > 0
+	results.breaker = newBreaker()
 	go CollectResults(results)
+	if search != nil {
+		go search.run(results)
+	}
+	if schedule != nil {
+		go runClientSchedule(schedule)
+	}
+	if *dashboardFlag {
+		go runDashboard()
+	}
 
 	for loop := 0; loop < *repeat && !stop; loop++ {
 		reader, file := OpenInput()
@@ -173,14 +331,30 @@ func Exec(clientFactory ClientFactory) *Results {
 		}
 		feedInput(reader, file)
 	}
+	launchMutex.Lock()
+	inputClosed = true
 	close(inputChan)
+	launchMutex.Unlock()
 
 	log.Println("Waiting clients to finish")
 	waitGroup.Wait()
 	close(outputChan)
+	results.Clients = clientHistory
 
 	log.Println("Waiting done from collect")
 	<-done
+	if search != nil {
+		<-searchDone
+	}
+	if *dashboardFlag {
+		<-dashboardDone
+	}
+	if *serverStatsURL != "" {
+		results.Server, err = FetchServerStats(*serverStatsURL, false)
+		if err != nil {
+			fmt.Println("Cannot get server stats:", err)
+		}
+	}
 	return results
 }
 
@@ -222,59 +396,148 @@ func feedInput(reader io.Reader, file *os.File) {
 
 // LaunchClients creates clients and starts the go routines for data processing
 func LaunchClients(clientFactory ClientFactory) error {
-	clients = make([]Client, *numClients)
-	var err error
-	for i := 0; i < *numClients; i++ {
-		config := ClientConfig{i, Parse(*cmdTemplate), *numClients, *verbose}
-		clients[i], err = clientFactory(config)
-		if err != nil {
-			return err
-		}
-		go ClientRoutine(i, clients[i])
-	}
-	return nil
+	factory = clientFactory
+	return SetClients(*numClients)
 }
 
-// ClientRoutine is the processing function for data processing
-func ClientRoutine(id int, client Client) {
-	if *delay > 0 {
+// ClientRoutine is the processing function for data processing, it exits when the input
+// ends or quit is closed. The waitGroup must be incremented before starting it.
+func ClientRoutine(id int, client Client, quit <-chan bool) {
+	if *delay > 0 && id < *numClients {
 		d := time.Duration(int(*delay) * id)
 		log.Printf("client %d delayed by %v\n", id, d)
 		time.Sleep(d)
 	}
 
 	log.Printf("client %d started\n", id)
-	waitGroup.Add(1)
 	defer log.Printf("client %d exited\n", id)
 	defer waitGroup.Done()
 
-	var throttle <-chan time.Time
-	if *rateLimit > 0 {
-		throttle = time.Tick(time.Duration(1e6/(*rateLimit)) * time.Microsecond)
+	var limiter throttle
+	defer limiter.stop()
+	for {
+		var input *ClientInput
+		select {
+		case input = <-inputChan:
+		case <-quit:
+		}
+		if input == nil {
+			break
+		}
+		waitPause()
+		limiter.wait()
+		var res *ClientResult
+		if *retries > 0 {
+			res = runWithRetries(client, input)
+		} else {
+			res = client.RunCommand(input)
+		}
+		if res != nil {
+			sendResult(client, res)
+		}
 	}
-	for input := range inputChan {
-		if *rateLimit > 0 {
-			<-throttle
+	if flusher, ok := client.(Flusher); ok {
+		if res := flusher.Flush(); res != nil {
+			sendResult(client, res)
 		}
-		res := client.RunCommand(input)
-		outputChan <- res
 	}
 	client.Term()
 }
 
+// throttle limits the rate of one client to the current clientInterval
+type throttle struct {
+	interval int64
+	ticker   *time.Ticker
+}
+
+func (t *throttle) wait() {
+	if interval := atomic.LoadInt64(&clientInterval); interval != t.interval {
+		t.stop()
+		t.interval = interval
+		if interval > 0 {
+			t.ticker = time.NewTicker(time.Duration(interval))
+		}
+	}
+	if t.ticker != nil {
+		<-t.ticker.C
+	}
+}
+
+func (t *throttle) stop() {
+	if t.ticker != nil {
+		t.ticker.Stop()
+		t.ticker = nil
+	}
+}
+
+// sendResult classifies the errors if the client has a classifier and outputs the result
+func sendResult(client Client, res *ClientResult) {
+	if classifier, ok := client.(ErrorClassifier); ok {
+		classifyErrors(classifier, res)
+	}
+	outputChan <- res
+}
+
+// collect updates the results with the command and its extra results, e.g. pipelined ones
+func (results *Results) collect(res *ClientResult) {
+	for _, extra := range res.Extra {
+		results.Update(extra)
+		if results.breaker != nil {
+			results.checkBreaker(extra)
+		}
+	}
+	results.Update(res)
+	if results.breaker != nil {
+		results.checkBreaker(res)
+	}
+}
+
 // CollectResults listens for processing results and updates the results
 func CollectResults(results *Results) {
 	log.Println("Waiting results")
-	for res := range outputChan {
-		results.Update(res)
+	for {
+		select {
+		case res, ok := <-outputChan:
+			if !ok {
+				log.Println("Results collected")
+				close(done)
+				return
+			}
+			results.collect(res)
+		case f := <-collectorChan:
+			f(results)
+		}
+	}
+}
+
+// inCollector runs the function with the results in the result collector, returns false
+// if the results have been collected
+func inCollector(f func(results *Results)) bool {
+	ran := make(chan bool)
+	select {
+	case collectorChan <- func(results *Results) { f(results); close(ran) }:
+		<-ran
+		return true
+	case <-done:
+		return false
 	}
-	log.Println("Results collected")
-	close(done)
 }
 
-// FeedCmds feeds the clients with command lines
+// TakeSnapshot returns the stats since the previous snapshot from the result collector,
+// nil if the results have been collected
+func TakeSnapshot() *Snapshot {
+	var snap *Snapshot
+	inCollector(func(results *Results) { snap = results.Snapshot() })
+	return snap
+}
+
+// FeedCmds feeds the clients with command lines, or with commands ending with
+// the -s separator, which may then span multiple lines
 func FeedCmds(reader io.Reader) error {
 	log.Println("Feeding commands")
+	if *separator != "" {
+		return feedSeparated(reader, *separator)
+	}
 	bufreader := bufio.NewReader(reader)
 	for !stop {
 		cmd, err := bufreader.ReadString('\n')
@@ -290,6 +553,50 @@ func FeedCmds(reader io.Reader) error {
 	return nil
 }
 
+// feedSeparated feeds the commands split at the separator, the separator is removed and
+// surrounding whitespace trimmed, empty commands are skipped
+func feedSeparated(reader io.Reader, sep string) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, maxCommandSize)
+	scanner.Split(commandSplitter(sep))
+	for !stop && scanner.Scan() {
+		if cmd := strings.TrimSpace(scanner.Text()); cmd != "" {
+			inputChan <- &ClientInput{Cmd: cmd}
+		}
+	}
+	return scanner.Err()
+}
+
+// commandSplitter returns the split function of the clients, or splits at every separator
+func commandSplitter(sep string) bufio.SplitFunc {
+	launchMutex.Lock()
+	defer launchMutex.Unlock()
+	if len(pool) > 0 {
+		if splitter, ok := pool[0].client.(CommandSplitter); ok {
+			return splitter.SplitCommands(sep)
+		}
+	}
+	return SplitAt(sep, nil)
+}
+
+// SplitAt returns a split function for the commands ending with the separator. The index
+// function returns the position of the first separator in the data, or -1 if none, the
+// default is bytes.Index.
+func SplitAt(sep string, index func(data, sep []byte) int) bufio.SplitFunc {
+	if index == nil {
+		index = bytes.Index
+	}
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := index(data, []byte(sep)); i >= 0 {
+			return i + len(sep), data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
 // FeedArgs feeds the clients with arguments to patch to template
 func FeedArgs(reader *csv.Reader) error {
 	log.Println("Feeding args")
@@ -0,0 +1,68 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFeedSeparated(t *testing.T) {
+	*separator = ";"
+	defer func() { *separator = "" }()
+
+	input := "CREATE TABLE t (\n  a INTEGER\n);\n\nINSERT INTO t VALUES (1);;\nSELECT *\nFROM t\n"
+	want := []string{"CREATE TABLE t (\n  a INTEGER\n)", "INSERT INTO t VALUES (1)", "SELECT *\nFROM t"}
+	errc := make(chan error)
+	go func() { errc <- FeedCmds(strings.NewReader(input)) }()
+	for _, w := range want {
+		if in := <-inputChan; in.Cmd != w {
+			t.Errorf("Cmd = %q, want %q", in.Cmd, w)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Error(err)
+	}
+}
+
+// quotingClient does not split the commands inside quotes
+type quotingClient struct{}
+
+func (quotingClient) RunCommand(in *ClientInput) *ClientResult { return nil }
+func (quotingClient) Term()                                    {}
+
+func (quotingClient) SplitCommands(sep string) bufio.SplitFunc {
+	return SplitAt(sep, func(data, sep []byte) int {
+		quoted := false
+		for i := range data {
+			if data[i] == '\'' {
+				quoted = !quoted
+			} else if !quoted && bytes.HasPrefix(data[i:], sep) {
+				return i
+			}
+		}
+		return -1
+	})
+}
+
+func TestFeedSeparatedSplitter(t *testing.T) {
+	*separator = ";"
+	pool = []poolClient{{client: quotingClient{}}}
+	defer func() { *separator = ""; pool = nil }()
+
+	input := "INSERT INTO t VALUES ('a;b');\nSELECT ';'"
+	want := []string{"INSERT INTO t VALUES ('a;b')", "SELECT ';'"}
+	errc := make(chan error)
+	go func() { errc <- FeedCmds(strings.NewReader(input)) }()
+	for _, w := range want {
+		if in := <-inputChan; in.Cmd != w {
+			t.Errorf("Cmd = %q, want %q", in.Cmd, w)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,115 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// poolClient is an active client, its routine exits when quit is closed
+type poolClient struct {
+	client Client
+	quit   chan bool
+}
+
+// ClientCount is the number of active clients since the time
+type ClientCount struct {
+	Time    time.Time
+	Clients int
+}
+
+// clientStep sets the number of clients at given time after the start
+type clientStep struct {
+	at      time.Duration
+	clients int
+}
+
+var factory ClientFactory
+var pool []poolClient // active clients, newest last
+var nextID int
+var clientHistory []ClientCount
+var launchMutex sync.Mutex
+var inputClosed bool // no more clients can be started
+var schedule []clientStep
+
+// SetClients starts or stops clients to have n active clients. The stopped clients finish
+// their current command and then Flush and Term. Nothing is done after the input has ended.
+func SetClients(n int) error {
+	if n < 1 {
+		return errors.New("At least 1 client needed")
+	}
+	launchMutex.Lock()
+	defer launchMutex.Unlock()
+	if inputClosed || n == len(pool) {
+		return nil
+	}
+	for len(pool) < n {
+		config := ClientConfig{nextID, Parse(*cmdTemplate), n, *verbose}
+		client, err := factory(config)
+		if err != nil {
+			return err
+		}
+		quit := make(chan bool)
+		pool = append(pool, poolClient{client, quit})
+		waitGroup.Add(1)
+		go ClientRoutine(nextID, client, quit)
+		nextID++
+	}
+	for len(pool) > n {
+		close(pool[len(pool)-1].quit)
+		pool = pool[:len(pool)-1]
+	}
+	clientHistory = append(clientHistory, ClientCount{time.Now(), n})
+	return nil
+}
+
+// parseClientSchedule parses the comma-separated 'duration:clients' changes
+func parseClientSchedule(spec string) ([]clientStep, error) {
+	var steps []clientStep
+	for _, field := range strings.Split(spec, ",") {
+		kv := strings.SplitN(field, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("expected 'duration:clients' in '%s'", field)
+		}
+		at, err := time.ParseDuration(kv[0])
+		if err != nil || at < 0 {
+			return nil, fmt.Errorf("invalid duration '%s'", kv[0])
+		}
+		n, err := strconv.Atoi(kv[1])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid number of clients '%s'", kv[1])
+		}
+		steps = append(steps, clientStep{at, n})
+	}
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].at < steps[j].at })
+	return steps, nil
+}
+
+// runClientSchedule changes the number of clients at the scheduled times
+func runClientSchedule(steps []clientStep) {
+	start := time.Now()
+	for _, step := range steps {
+		if !sleepUntilStop(time.Until(start.Add(step.at))) {
+			return
+		}
+		fmt.Printf("%s %d clients   \n", time.Now().Format("15:04:05"), step.clients)
+		if err := SetClients(step.clients); err != nil {
+			fmt.Println("Cannot change clients:", err)
+		}
+	}
+}
+
+// reportClients prints the number of active clients after each change
+func (results *Results) reportClients() {
+	fmt.Println("Active clients:")
+	for _, c := range results.Clients {
+		fmt.Printf("%s\t%d\n", c.Time.Format("15:04:05"), c.Clients)
+	}
+}
@@ -0,0 +1,68 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseClientSchedule(t *testing.T) {
+	steps, err := parseClientSchedule("1m:20,30s:10,2m:5")
+	want := []clientStep{{30 * time.Second, 10}, {time.Minute, 20}, {2 * time.Minute, 5}}
+	if err != nil || !reflect.DeepEqual(steps, want) {
+		t.Errorf("parseClientSchedule = %v, %v", steps, err)
+	}
+	for _, spec := range []string{"30s", "30:10", "30s:0", "30s:x", "-1s:2"} {
+		if _, err = parseClientSchedule(spec); err == nil {
+			t.Errorf("parseClientSchedule accepted '%s'", spec)
+		}
+	}
+}
+
+// termClient reports its Term to the channel
+type termClient struct {
+	id    int
+	terms chan int
+}
+
+func (c *termClient) RunCommand(input *ClientInput) *ClientResult { return &ClientResult{} }
+func (c *termClient) Term()                                       { c.terms <- c.id }
+
+func TestSetClients(t *testing.T) {
+	terms := make(chan int, 10)
+	factory = func(config ClientConfig) (Client, error) {
+		return &termClient{config.ID, terms}, nil
+	}
+	defer func() {
+		for _, c := range pool {
+			close(c.quit)
+		}
+		waitGroup.Wait()
+		factory, pool, nextID, clientHistory = nil, nil, 0, nil
+	}()
+
+	if err := SetClients(3); err != nil || len(pool) != 3 {
+		t.Fatalf("SetClients(3) = %v, %d clients", err, len(pool))
+	}
+	SetClients(1)
+	for _, want := range []int{2, 1} {
+		select {
+		case id := <-terms:
+			if id != want && id != 3-want {
+				t.Errorf("client %d stopped", id)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("client not stopped")
+		}
+	}
+	SetClients(2)
+	if pool[1].client.(*termClient).id != 3 || len(clientHistory) != 3 || clientHistory[2].Clients != 2 {
+		t.Errorf("pool = %v, history = %v", pool, clientHistory)
+	}
+	if err := SetClients(0); err == nil {
+		t.Error("SetClients(0) accepted")
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"sort"
 	"strings"
 	"time"
+
+	"github.com/jkarjala/gompet/stats"
 )
 
 // Results collects overall statistics
@@ -22,11 +24,83 @@ type Results struct {
 	Count         int64
 	LastCount     int64
 	Times         []float64
+	Latency       Histogram // latencies of the whole run, not cleared by the periodic stats
 	Results       map[string]int64
-	Errs          map[string]int64
+	Errs          map[string]int64    // error counts by class
+	ErrSamples    map[string][]string // raw error messages of each class
+	Conns         int64
+	Timings       map[string][]float64
+	TimingNames   []string
+	ReqBytes      int64
+	RespBytes     int64
+	Decoded       int64
+	LastReqBytes  int64
+	LastRespBytes int64
+	Sizes         []float64    // response sizes of successful commands
+	ShowBytes     bool         // include bandwidth in periodic stats rows
+	Server        *ServerStats // server-side stats of the run, if requested
+	Retry         bool         // retries enabled, first attempts and retries are reported
+	FirstResults  map[string]int64
+	FirstErrs     map[string]int64
+	Retries       int64     // total retries
+	Retried       int64     // commands retried at least once
+	Recovered     int64     // retried commands which succeeded finally
+	TotalTimes    []float64 // execution times including retries
+	Aborted       string    // reason if the run was aborted by the error breaker
+	Pauses        []string  // times and reasons of the error breaker pauses
+	breaker       *breaker
+	Search        []SearchStep  // steps of the throughput search
+	SearchParam   string        // stepped parameter, Rate or Clients
+	SearchEnd     string        // reason the search ended
+	Clients       []ClientCount // active clients after each change
+	snap          Snapshot      // stats since the previous snapshot
+	live          Snapshot      // stats since the previous dashboard refresh
+}
+
+// Snapshot is the throughput and latency since the previous snapshot
+type Snapshot struct {
+	Start   time.Time
+	Elapsed float64 // seconds
+	Count   int64
+	Errs    int64
+	Latency Histogram
+}
+
+func (s *Snapshot) add(res *ClientResult) {
+	s.Count++
+	s.Latency.Add(res.Time)
+	if res.Err != nil {
+		s.Errs++
+	}
+}
+
+// copy returns a copy with the elapsed time until now
+func (s *Snapshot) copy() *Snapshot {
+	c := *s
+	c.Elapsed = time.Since(s.Start).Seconds()
+	c.Latency.Counts = append([]int64(nil), s.Latency.Counts...)
+	return &c
+}
+
+// take returns a copy and starts a new snapshot
+func (s *Snapshot) take() *Snapshot {
+	c := s.copy()
+	s.Start = time.Now()
+	s.Count = 0
+	s.Errs = 0
+	s.Latency.Reset()
+	return c
 }
 
-var percentiles = []float64{50, 90, 95, 98, 100}
+// Rate returns the commands per second of the snapshot
+func (s *Snapshot) Rate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Count) / s.Elapsed
+}
+
+var percentiles = stats.Percentiles
 
 var statsChan chan Results
 var statsDone chan bool
@@ -40,8 +114,15 @@ func NewResults(progress bool, periodicStats int) *Results {
 	results.PeriodicStats = periodicStats
 	results.Progress = progress
 	results.Times = make([]float64, 0)
+	results.Sizes = make([]float64, 0)
 	results.Results = make(map[string]int64)
 	results.Errs = make(map[string]int64)
+	results.ErrSamples = make(map[string][]string)
+	results.Timings = make(map[string][]float64)
+	results.FirstResults = make(map[string]int64)
+	results.FirstErrs = make(map[string]int64)
+	results.snap.Start = results.Start
+	results.live.Start = results.Start
 	if periodicStats > 0 {
 		statsChan = make(chan Results, 2) // buffer to reduce blocking the Update
 		statsDone = make(chan bool)
@@ -54,6 +135,7 @@ func NewResults(progress bool, periodicStats int) *Results {
 func (results *Results) Update(res *ClientResult) {
 	results.Count++
 	results.Times = append(results.Times, res.Time)
+	results.Latency.Add(res.Time)
 	now := time.Now()
 	if now.Sub(results.LastProgress) > 1*time.Second {
 		results.Elapsed = now.Sub(results.Start).Seconds()
@@ -68,7 +150,10 @@ func (results *Results) Update(res *ClientResult) {
 			// must allocate a new buffer to preserve old for reporter
 			results.Times = make([]float64, len(results.Times)) // old size is good estimate
 			results.Times = results.Times[:0]                   // clear the slice to append results later
+			results.Sizes = make([]float64, 0, len(results.Sizes))
 			results.LastCount = results.Count
+			results.LastReqBytes = results.ReqBytes
+			results.LastRespBytes = results.RespBytes
 			results.LastStats = now
 		}
 		results.LastProgress = now
@@ -77,7 +162,66 @@ func (results *Results) Update(res *ClientResult) {
 		results.Results[res.Res]++
 	}
 	if res.Err != nil {
-		results.Errs[fmt.Sprintf("%s", res.Err)]++
+		class := errorClass(res)
+		results.Errs[class]++
+		results.addErrSample(class, res.Err)
+	}
+	results.snap.add(res)
+	results.live.add(res)
+	results.Conns += int64(res.Conns)
+	results.ReqBytes += res.ReqBytes
+	results.RespBytes += res.RespBytes
+	results.Decoded += res.Decoded
+	if res.Err == nil {
+		results.Sizes = append(results.Sizes, float64(res.RespBytes))
+	}
+	for _, t := range res.Timings {
+		times, ok := results.Timings[t.Name]
+		if !ok {
+			results.TimingNames = append(results.TimingNames, t.Name)
+		}
+		results.Timings[t.Name] = append(times, t.Time)
+	}
+	if results.Retry {
+		results.updateRetries(res)
+	}
+}
+
+// Snapshot returns the stats since the previous snapshot and starts a new one
+func (results *Results) Snapshot() *Snapshot {
+	return results.snap.take()
+}
+
+// peek returns the stats since the previous snapshot without starting a new one
+func (results *Results) peek() *Snapshot {
+	return results.snap.copy()
+}
+
+// updateRetries updates the first attempt outcomes and retry counts
+func (results *Results) updateRetries(res *ClientResult) {
+	first := res
+	if res.First != nil {
+		first = res.First
+	}
+	if first.Res != "" {
+		results.FirstResults[first.Res]++
+	}
+	if first.Err != nil {
+		results.FirstErrs[errorClass(first)]++
+	}
+	if res.Retries > 0 {
+		results.Retries += int64(res.Retries)
+		results.Retried++
+		if res.Err == nil && !retryable(res) {
+			results.Recovered++ // the final attempt succeeded
+		}
+	}
+	if results.PeriodicStats == 0 {
+		total := res.TotalTime
+		if total == 0 {
+			total = res.Time // e.g. Extra results
+		}
+		results.TotalTimes = append(results.TotalTimes, total)
 	}
 }
 
@@ -94,11 +238,17 @@ func (results *Results) Report() {
 	}
 	PrintMap("Result counts:", results.Results)
 	if len(results.Errs) > 0 {
-		PrintMap("Error counts:", results.Errs)
+		printErrors("Error counts:", results.Errs, results.ErrSamples)
 	}
 	cps := FormatDecimals(float64(results.Count) / elapsed)
 	fmt.Printf("Total %d commands in %0.1f seconds, %s cmds/sec\n", results.Count, elapsed, cps)
-	if results.PeriodicStats == 0 {
+	for _, pause := range results.Pauses {
+		fmt.Printf("Paused at %s\n", pause)
+	}
+	if results.Aborted != "" {
+		fmt.Printf("Aborted after %s\n", results.Aborted)
+	}
+	if results.PeriodicStats == 0 && results.Server == nil {
 		fmt.Println("Latency percentiles:")
 		sort.Float64s(results.Times)
 		// fmt.Printf("Times:%v\n", results.Times)
@@ -106,6 +256,85 @@ func (results *Results) Report() {
 			PrintPercentile(results.Times, p)
 		}
 	}
+	if results.Server != nil {
+		results.reportServer()
+	}
+	if results.Retry {
+		results.reportRetries()
+	}
+	if results.SearchParam != "" {
+		results.reportSearch()
+	}
+	if len(results.Clients) > 1 {
+		results.reportClients()
+	}
+	if results.ReqBytes+results.RespBytes > 0 {
+		fmt.Printf("Received %s, %s/sec, sent %s, %s/sec\n",
+			FormatBytes(float64(results.RespBytes)), FormatBytes(float64(results.RespBytes)/elapsed),
+			FormatBytes(float64(results.ReqBytes)), FormatBytes(float64(results.ReqBytes)/elapsed))
+		if results.Decoded > 0 {
+			ratio := FormatDecimals(float64(results.Decoded) / float64(results.RespBytes))
+			fmt.Printf("Received %s after decompression, %sx compression\n", FormatBytes(float64(results.Decoded)), ratio)
+		}
+		if results.PeriodicStats == 0 {
+			fmt.Println("Response size percentiles:")
+			sort.Float64s(results.Sizes)
+			for _, p := range percentiles {
+				fmt.Printf("%3.0f%%\t%s\n", p, FormatBytes(Percentile(results.Sizes, p)))
+			}
+		}
+	}
+	if results.Conns > 0 {
+		perConn := FormatDecimals(float64(results.Count) / float64(results.Conns))
+		fmt.Printf("New connections: %d, %s cmds/connection\n", results.Conns, perConn)
+	}
+	if len(results.TimingNames) > 0 {
+		fmt.Println("Phase percentiles:")
+		fmt.Println(results.TimingRowHeader())
+		for _, name := range results.TimingNames {
+			fmt.Println(results.TimingRow(name))
+		}
+	}
+}
+
+// reportRetries prints the first attempt outcomes, retry counts and latency including retries
+func (results *Results) reportRetries() {
+	PrintMap("First attempt result counts:", results.FirstResults)
+	if len(results.FirstErrs) > 0 {
+		PrintMap("First attempt error counts:", results.FirstErrs)
+	}
+	fmt.Printf("Retries: %d for %d commands, %d recovered\n", results.Retries, results.Retried, results.Recovered)
+	if results.PeriodicStats == 0 {
+		fmt.Println("Latency percentiles including retries:")
+		sort.Float64s(results.TotalTimes)
+		for _, p := range percentiles {
+			PrintPercentile(results.TotalTimes, p)
+		}
+	}
+}
+
+// reportServer prints the server-side percentiles next to the client-side ones, the difference
+// is the network and client overhead, and the server request counts. Both sides use the
+// histogram of the whole run since the Times are cleared with the periodic stats.
+func (results *Results) reportServer() {
+	server := results.Server
+	fmt.Println("Latency percentiles:\tClient\tServer\tOverhead")
+	for _, p := range percentiles {
+		client := results.Latency.Percentile(p) * 1000
+		if t, ok := server.Time(p); ok {
+			fmt.Printf("%3.0f%%\t%s ms\t%s ms\t%s ms\n", p, FormatDecimals(client),
+				FormatDecimals(t*1000), FormatDecimals(client-t*1000))
+		} else {
+			fmt.Printf("%3.0f%%\t%s ms\t-\t-\n", p, FormatDecimals(client))
+		}
+	}
+	var statuses []string
+	for status, count := range server.Statuses {
+		statuses = append(statuses, fmt.Sprintf("%s: %d", status, count))
+	}
+	sort.Strings(statuses)
+	fmt.Printf("Server requests: %d in %0.1f seconds, max in-flight %d, statuses %s\n",
+		server.Requests, server.Elapsed, server.MaxInFlight, strings.Join(statuses, ", "))
 }
 
 // PercentileRowHeader returns the header for the stats rows
@@ -115,6 +344,9 @@ func (results *Results) PercentileRowHeader() string {
 		res += fmt.Sprintf("%.0f%% ms\t", p)
 	}
 	res += "Cmds\tCmds/s\tTotal"
+	if results.ShowBytes {
+		res += "\tIn/s\tOut/s\tSize 50%\tSize 100%"
+	}
 	return res
 }
 
@@ -132,6 +364,38 @@ func (results *Results) PercentileRow() string {
 	c := results.Count - results.LastCount
 	cps := FormatDecimals(float64(c) / float64(results.PeriodicStats))
 	res.WriteString(fmt.Sprintf("%d\t%s\t%d", c, cps, results.Count))
+	if results.ShowBytes {
+		in := float64(results.RespBytes-results.LastRespBytes) / float64(results.PeriodicStats)
+		out := float64(results.ReqBytes-results.LastReqBytes) / float64(results.PeriodicStats)
+		sort.Float64s(results.Sizes)
+		res.WriteString(fmt.Sprintf("\t%s\t%s\t%s\t%s", FormatBytes(in), FormatBytes(out),
+			FormatBytes(Percentile(results.Sizes, 50)), FormatBytes(Percentile(results.Sizes, 100))))
+	}
+	return res.String()
+}
+
+// TimingRowHeader returns the header for the phase timing rows
+func (results *Results) TimingRowHeader() string {
+	var res = "Phase\t"
+	for _, p := range percentiles {
+		res += fmt.Sprintf("%.0f%% ms\t", p)
+	}
+	res += "Count"
+	return res
+}
+
+// TimingRow formats a row of percentiles for the named phase
+func (results *Results) TimingRow(name string) string {
+	var res strings.Builder
+	times := results.Timings[name]
+	sort.Float64s(times)
+	res.WriteString(name)
+	res.WriteString("\t")
+	for _, p := range percentiles {
+		res.WriteString(FormatDecimals(Percentile(times, p) * 1000))
+		res.WriteString("\t")
+	}
+	res.WriteString(fmt.Sprintf("%d", len(times)))
 	return res.String()
 }
 
@@ -149,6 +413,19 @@ func FormatDecimals(v float64) string {
 	}
 }
 
+// FormatBytes returns the byte count as a string with a unit and "nice" number of decimals
+func FormatBytes(v float64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	i := 0
+	for ; v >= 1000 && i < len(units)-1; i++ {
+		v /= 1000
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f B", v)
+	}
+	return FormatDecimals(v) + " " + units[i]
+}
+
 // PrintMap outputs heading and map key values in increasing alphabetical order of keys
 func PrintMap(heading string, m map[string]int64) {
 	fmt.Println(heading)
@@ -201,10 +478,14 @@ func Percentile(input []float64, percent float64) (percentile float64) {
 
 // Go routine to report progress during test without blocking the test
 func statsReporter() {
+	var showBytes bool // columns are fixed by the first row
 	for results := range statsChan {
 		if results.LastCount == 0 {
+			showBytes = results.ReqBytes+results.RespBytes > 0
+			results.ShowBytes = showBytes
 			fmt.Println(results.PercentileRowHeader())
 		}
+		results.ShowBytes = showBytes
 		fmt.Println(results.PercentileRow())
 	}
 	statsDone <- true
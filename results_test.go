@@ -0,0 +1,49 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUpdateTimings(t *testing.T) {
+	results := NewResults(false, 0)
+	results.Update(&ClientResult{Time: 0.003, Timings: []Timing{{"Connect", 0.001}, {"TTFB", 0.002}}})
+	results.Update(&ClientResult{Time: 0.001, Timings: []Timing{{"TTFB", 0.001}}})
+	if !reflect.DeepEqual(results.TimingNames, []string{"Connect", "TTFB"}) {
+		t.Errorf("TimingNames = %v", results.TimingNames)
+	}
+	if got := results.TimingRow("TTFB"); got != "TTFB\t1.00\t1.50\t1.50\t1.50\t2.00\t2" {
+		t.Errorf("TimingRow = '%v'", got)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for v, want := range map[float64]string{0: "0 B", 999: "999 B", 1500: "1.50 KB", 2.5e6: "2.50 MB", 3e12: "3000 GB"} {
+		if got := FormatBytes(v); got != want {
+			t.Errorf("FormatBytes(%v) = '%v', want '%v'", v, got, want)
+		}
+	}
+}
+
+func TestServerPercentilesWithPeriodicStats(t *testing.T) {
+	results := NewResults(false, 1)
+	results.Update(&ClientResult{Time: 0.001})
+	results.LastProgress = time.Now().Add(-2 * time.Second)
+	results.LastStats = results.LastProgress
+	results.Update(&ClientResult{Time: 0.2}) // starts a new period
+	results.Update(&ClientResult{Time: 0.001})
+	if len(results.Times) != 1 {
+		t.Fatalf("Times not cleared by the period: %v", results.Times)
+	}
+	var server Histogram
+	server.Add(0.1)
+	results.Server = NewServerStats(&server)
+	results.Report()
+	if results.Latency.Total != 3 || results.Latency.Percentile(100) < 0.2 {
+		t.Errorf("whole-run latencies %d, max %v", results.Latency.Total, results.Latency.Percentile(100))
+	}
+}
@@ -0,0 +1,73 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"math/rand"
+	"regexp"
+	"time"
+)
+
+var retryPattern *regexp.Regexp // compiled -retry-on, nil retries all errors
+
+// retryable tells if the failed command should be retried: errors are retried by default,
+// with -retry-on the errors and results matching it are retried
+func retryable(res *ClientResult) bool {
+	if retryPattern == nil {
+		return res.Err != nil
+	}
+	if res.Err != nil {
+		return retryPattern.MatchString(res.Err.Error())
+	}
+	return retryPattern.MatchString(res.Res)
+}
+
+// backoff returns the random delay before the retry, up to exponentially growing limit
+func backoff(retry int) time.Duration {
+	limit := *retryBackoff << uint(retry-1)
+	if limit > *retryMaxBackoff || limit <= 0 {
+		limit = *retryMaxBackoff
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit)))
+}
+
+// runWithRetries runs the command and retries it according to the retry policy.
+// The final result has the retry count, the first attempt, the total time including
+// the retries and backoffs, and the connections, bytes and extra results of all attempts.
+func runWithRetries(client Client, input *ClientInput) *ClientResult {
+	res := client.RunCommand(input)
+	if res == nil {
+		return nil // deferred results are not retried
+	}
+	first := res
+	total := res.Time
+	conns, reqBytes, respBytes, decoded := res.Conns, res.ReqBytes, res.RespBytes, res.Decoded
+	var extra []*ClientResult
+	for retry := 1; retry <= *retries && retryable(res) && !stop; retry++ {
+		delay := backoff(retry)
+		time.Sleep(delay)
+		next := client.RunCommand(input)
+		if next == nil {
+			break
+		}
+		total += delay.Seconds() + next.Time
+		conns += next.Conns
+		reqBytes += next.ReqBytes
+		respBytes += next.RespBytes
+		decoded += next.Decoded
+		extra = append(extra, res.Extra...)
+		res = next
+		res.Retries = retry
+	}
+	if res != first {
+		res.First = first
+		res.Extra = append(extra, res.Extra...)
+	}
+	res.TotalTime = total
+	res.Conns, res.ReqBytes, res.RespBytes, res.Decoded = conns, reqBytes, respBytes, decoded
+	return res
+}
@@ -0,0 +1,121 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// flakyClient fails the given number of times with the error, then succeeds
+type flakyClient struct {
+	failures int
+	err      error
+	calls    int
+}
+
+func (c *flakyClient) RunCommand(input *ClientInput) *ClientResult {
+	c.calls++
+	hop := &ClientResult{Res: "301 Moved Permanently"}
+	if c.calls <= c.failures {
+		return &ClientResult{Err: c.err, Time: 0.01, Conns: 1, ReqBytes: 10, Extra: []*ClientResult{hop}}
+	}
+	return &ClientResult{Res: "200 OK", Time: 0.01, Conns: 1, ReqBytes: 10, RespBytes: 100, Extra: []*ClientResult{hop}}
+}
+
+func (c *flakyClient) Term() {}
+
+func setRetries(n int, pattern string) func() {
+	*retries = n
+	*retryBackoff = time.Millisecond
+	if pattern != "" {
+		retryPattern = regexp.MustCompile(pattern)
+	}
+	return func() {
+		*retries = 0
+		*retryBackoff = 100 * time.Millisecond
+		retryPattern = nil
+	}
+}
+
+func TestRunWithRetries(t *testing.T) {
+	defer setRetries(3, "")()
+
+	client := &flakyClient{failures: 2, err: errors.New("connection reset")}
+	res := runWithRetries(client, &ClientInput{Cmd: "GET /"})
+	if res.Err != nil || res.Retries != 2 || client.calls != 3 {
+		t.Errorf("recovered: %+v after %d calls", res, client.calls)
+	}
+	if res.First == nil || res.First.Err == nil || res.TotalTime < 0.03 {
+		t.Errorf("first = %+v, total time %f", res.First, res.TotalTime)
+	}
+	if res.Conns != 3 || res.ReqBytes != 30 || res.RespBytes != 100 || len(res.Extra) != 3 {
+		t.Errorf("sums of attempts: %+v", res)
+	}
+
+	client = &flakyClient{failures: 5, err: errors.New("connection reset")}
+	res = runWithRetries(client, &ClientInput{Cmd: "GET /"})
+	if res.Err == nil || res.Retries != 3 || client.calls != 4 {
+		t.Errorf("exhausted: %+v after %d calls", res, client.calls)
+	}
+
+	client = &flakyClient{}
+	res = runWithRetries(client, &ClientInput{Cmd: "GET /"})
+	if res.Retries != 0 || res.First != nil || client.calls != 1 || res.TotalTime != res.Time || len(res.Extra) != 1 {
+		t.Errorf("no retry: %+v after %d calls", res, client.calls)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	defer setRetries(1, `^5\d\d |reset`)()
+
+	tests := []struct {
+		res  ClientResult
+		want bool
+	}{
+		{ClientResult{Res: "503 Service Unavailable"}, true},
+		{ClientResult{Res: "400 Bad Request"}, false},
+		{ClientResult{Res: "200 OK"}, false},
+		{ClientResult{Err: errors.New("read: connection reset by peer")}, true},
+		{ClientResult{Err: errors.New("timeout")}, false},
+	}
+	for _, test := range tests {
+		if got := retryable(&test.res); got != test.want {
+			t.Errorf("retryable(%+v) = %v, want %v", test.res, got, test.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	defer setRetries(5, "")()
+	*retryMaxBackoff = 4 * time.Millisecond
+	defer func() { *retryMaxBackoff = 5 * time.Second }()
+
+	for retry := 1; retry <= 5; retry++ {
+		limit := time.Millisecond << uint(retry-1)
+		if limit > *retryMaxBackoff {
+			limit = *retryMaxBackoff
+		}
+		for i := 0; i < 100; i++ {
+			if d := backoff(retry); d < 0 || d >= limit {
+				t.Fatalf("backoff(%d) = %v, want below %v", retry, d, limit)
+			}
+		}
+	}
+}
+
+func TestRecovered(t *testing.T) {
+	defer setRetries(2, `^5\d\d `)()
+
+	results := NewResults(false, 0)
+	first := &ClientResult{Res: "503 Service Unavailable"}
+	results.updateRetries(&ClientResult{Res: "200 OK", Retries: 1, First: first})
+	results.updateRetries(&ClientResult{Err: errors.New("timeout"), Retries: 1, First: first})
+	results.updateRetries(&ClientResult{Res: "502 Bad Gateway", Retries: 2, First: first})
+	if results.Retried != 3 || results.Retries != 4 || results.Recovered != 1 {
+		t.Errorf("retried %d, retries %d, recovered %d", results.Retried, results.Retries, results.Recovered)
+	}
+}
@@ -0,0 +1,232 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const searchMaxErrors = 0.01 // share of errors which makes the step unsustainable
+const searchMinGain = 0.02   // throughput increase required from the previous step with more clients
+const searchMinRate = 0.95   // share of the target rate which must be achieved
+const searchCheck = 100 * time.Millisecond
+
+// searchParams steps the total rate or the number of clients
+type searchParams struct {
+	clients    bool
+	start      int
+	step       int
+	max        int // 0 for no limit
+	sloPercent float64
+	slo        time.Duration // 0 for no latency limit
+}
+
+// SearchStep is the throughput and latency of one step of the search
+type SearchStep struct {
+	Value int // total rate or clients
+	Snapshot
+	Sustainable bool
+}
+
+var search *searchParams // nil unless -search
+var searchDone = make(chan bool)
+
+// parseSearch parses 'rate|clients:start:step[:max]' and the 'pNN=duration' latency limit
+func parseSearch(spec string, slo string) (*searchParams, error) {
+	var s searchParams
+	fields := strings.Split(spec, ":")
+	if len(fields) < 3 || len(fields) > 4 {
+		return nil, errors.New("use rate:start:step[:max] or clients:start:step[:max]")
+	}
+	switch fields[0] {
+	case "rate":
+	case "clients":
+		s.clients = true
+	default:
+		return nil, fmt.Errorf("unknown parameter '%s', use rate or clients", fields[0])
+	}
+	var values []int
+	for _, f := range fields[1:] {
+		v, err := strconv.Atoi(f)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid value '%s'", f)
+		}
+		values = append(values, v)
+	}
+	s.start, s.step = values[0], values[1]
+	if len(values) > 2 {
+		s.max = values[2]
+	}
+	if slo != "" {
+		kv := strings.SplitN(slo, "=", 2)
+		var err error
+		if len(kv) == 2 && strings.HasPrefix(kv[0], "p") {
+			s.sloPercent, err = strconv.ParseFloat(kv[0][1:], 64)
+			if err == nil {
+				s.slo, err = time.ParseDuration(kv[1])
+			}
+		}
+		if len(kv) != 2 || err != nil || s.sloPercent <= 0 || s.sloPercent > 100 || s.slo <= 0 {
+			return nil, fmt.Errorf("invalid latency limit '%s', use e.g. p95=50ms", slo)
+		}
+	}
+	return &s, nil
+}
+
+func (s *searchParams) name() string {
+	if s.clients {
+		return "Clients"
+	}
+	return "Rate"
+}
+
+// setup sets the flags for the first step
+func (s *searchParams) setup() {
+	if s.clients {
+		*numClients = s.start
+	} else {
+		s.setRate(s.start)
+	}
+}
+
+// setRate divides the total rate to the active clients, or to -c clients before the launch
+func (s *searchParams) setRate(rate int) {
+	launchMutex.Lock()
+	clients := len(pool)
+	launchMutex.Unlock()
+	if clients == 0 {
+		clients = *numClients
+	}
+	atomic.StoreInt64(&clientInterval, int64(clients)*int64(time.Second)/int64(rate))
+}
+
+// run executes the steps until a step is not sustainable, the max is reached or the run
+// is stopped. The stats of each step include the commands in flight at the change, at most
+// one per client, which were started with the previous rate or clients.
+func (s *searchParams) run(results *Results) {
+	defer close(searchDone)
+	var end string
+	defer func() {
+		updateResults(results, func(results *Results) { results.SearchEnd = end })
+	}()
+	updateResults(results, func(results *Results) { results.SearchParam = s.name() })
+	var best float64
+	for value := s.start; s.max == 0 || value <= s.max; value += s.step {
+		if value > s.start {
+			if s.clients {
+				if err := SetClients(value); err != nil {
+					end = err.Error()
+					break
+				}
+			} else {
+				s.setRate(value)
+			}
+		}
+		fmt.Printf("%s search step %s %d   \n", time.Now().Format("15:04:05"), s.name(), value)
+		TakeSnapshot()
+		if !sleepUntilStop(*searchHold) {
+			break
+		}
+		snap := TakeSnapshot()
+		if snap == nil {
+			break
+		}
+		step := SearchStep{Value: value, Snapshot: *snap}
+		reason := s.check(&step, best)
+		step.Sustainable = reason == ""
+		updateResults(results, func(results *Results) { results.Search = append(results.Search, step) })
+		if reason != "" {
+			end = reason
+			break
+		}
+		best = step.Rate()
+	}
+	if end == "" {
+		if stop {
+			end = "stopped"
+		} else {
+			end = fmt.Sprintf("max %d reached", s.max)
+		}
+	}
+	stop = true
+}
+
+// updateResults changes the results in the collector, or directly once they have been collected
+func updateResults(results *Results, f func(results *Results)) {
+	if !inCollector(f) {
+		f(results)
+	}
+}
+
+// check returns the reason if the step is not sustainable
+func (s *searchParams) check(step *SearchStep, best float64) string {
+	rate := step.Rate()
+	if step.Count > 0 && float64(step.Errs)/float64(step.Count) > searchMaxErrors {
+		return fmt.Sprintf("%0.1f%% errors", float64(step.Errs)/float64(step.Count)*100)
+	}
+	if s.slo > 0 {
+		if t := step.Latency.Percentile(s.sloPercent); t > s.slo.Seconds() {
+			return fmt.Sprintf("%g%% latency %s ms over %s", s.sloPercent, FormatDecimals(t*1000), s.slo)
+		}
+	}
+	if !s.clients && rate < searchMinRate*float64(step.Value) {
+		return fmt.Sprintf("%s cmds/sec below target rate", FormatDecimals(rate))
+	}
+	if s.clients && best > 0 && rate < best*(1+searchMinGain) {
+		return "throughput did not increase"
+	}
+	return ""
+}
+
+// sleepUntilStop sleeps the duration, returns false if the run was stopped
+func sleepUntilStop(d time.Duration) bool {
+	end := time.Now().Add(d)
+	for !stop {
+		left := time.Until(end)
+		if left <= 0 {
+			return true
+		}
+		if left > searchCheck {
+			left = searchCheck
+		}
+		time.Sleep(left)
+	}
+	return false
+}
+
+// reportSearch prints the throughput and latency of each step and the max sustainable throughput
+func (results *Results) reportSearch() {
+	fmt.Printf("Search steps:\n%s\tCmds/sec\tErrors", results.SearchParam)
+	for _, p := range percentiles {
+		fmt.Printf("\t%3.0f%%", p)
+	}
+	fmt.Println()
+	var max *SearchStep
+	for i := range results.Search {
+		step := &results.Search[i]
+		fmt.Printf("%d\t%s\t%d", step.Value, FormatDecimals(step.Rate()), step.Errs)
+		for _, p := range percentiles {
+			fmt.Printf("\t%s", FormatDecimals(step.Latency.Percentile(p)*1000))
+		}
+		if !step.Sustainable {
+			fmt.Print("\t*")
+		}
+		fmt.Println()
+		if step.Sustainable {
+			max = step
+		}
+	}
+	fmt.Println("Search ended:", results.SearchEnd)
+	if max != nil {
+		fmt.Printf("Max sustainable throughput: %s cmds/sec at %s %d\n",
+			FormatDecimals(max.Rate()), strings.ToLower(results.SearchParam), max.Value)
+	} else {
+		fmt.Println("Max sustainable throughput: none of the steps was sustainable")
+	}
+}
@@ -0,0 +1,113 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseSearch(t *testing.T) {
+	s, err := parseSearch("clients:2:4:20", "p99.9=50ms")
+	want := searchParams{true, 2, 4, 20, 99.9, 50 * time.Millisecond}
+	if err != nil || *s != want {
+		t.Errorf("parseSearch = %+v, %v", s, err)
+	}
+	for _, spec := range []string{"rate:100", "speed:1:1", "rate:0:100", "rate:x:100", "rate:1:2:3:4"} {
+		if _, err = parseSearch(spec, ""); err == nil {
+			t.Errorf("parseSearch accepted '%s'", spec)
+		}
+	}
+	for _, slo := range []string{"95=50ms", "p95", "p95=50", "p0=1s"} {
+		if _, err = parseSearch("rate:100:100", slo); err == nil {
+			t.Errorf("parseSearch accepted SLO '%s'", slo)
+		}
+	}
+}
+
+// newStep returns a step of 10 seconds with given count, errors and latencies
+func newStep(value int, count, errs int64, latency float64) *SearchStep {
+	step := SearchStep{Value: value, Snapshot: Snapshot{Elapsed: 10, Count: count, Errs: errs}}
+	for i := int64(0); i < count; i++ {
+		step.Latency.Add(latency)
+	}
+	return &step
+}
+
+func TestSearchCheck(t *testing.T) {
+	rate := searchParams{start: 100, step: 100, sloPercent: 95, slo: 50 * time.Millisecond}
+	clients := searchParams{clients: true, start: 1, step: 1}
+	tests := []struct {
+		s    *searchParams
+		step *SearchStep
+		best float64
+		want string
+	}{
+		{&rate, newStep(200, 2000, 0, 0.010), 100, ""},
+		{&rate, newStep(200, 1800, 0, 0.010), 100, "180 cmds/sec below target rate"},
+		{&rate, newStep(200, 2000, 100, 0.010), 100, "5.0% errors"},
+		{&rate, newStep(200, 2000, 0, 0.060), 100, "95% latency 60.2 ms over 50ms"},
+		{&clients, newStep(3, 3000, 0, 0.001), 200, ""},
+		{&clients, newStep(3, 2010, 0, 0.001), 200, "throughput did not increase"},
+	}
+	for _, test := range tests {
+		if got := test.s.check(test.step, test.best); got != test.want {
+			t.Errorf("check(%d) = '%s', want '%s'", test.step.Value, got, test.want)
+		}
+	}
+}
+
+func TestSetRate(t *testing.T) {
+	defer setClientRate(0)
+	defer func(n int) { *numClients = n; pool = nil }(*numClients)
+
+	s := &searchParams{}
+	*numClients = 4
+	s.setRate(400)
+	if interval := atomic.LoadInt64(&clientInterval); interval != int64(10*time.Millisecond) {
+		t.Errorf("before launch interval = %d", interval)
+	}
+	pool = make([]poolClient, 2)
+	s.setRate(400)
+	if interval := atomic.LoadInt64(&clientInterval); interval != int64(5*time.Millisecond) {
+		t.Errorf("2 clients interval = %d", interval)
+	}
+}
+
+func TestSearchRun(t *testing.T) {
+	*searchHold = 10 * time.Millisecond
+	searchDone = make(chan bool)
+	defer func() {
+		*searchHold = 10 * time.Second
+		stop = false
+		setClientRate(0)
+	}()
+	results := NewResults(false, 1)
+	quit := make(chan bool)
+	collected := make(chan bool)
+	go func() { // the result collector, copying the results like Update does with -S
+		defer close(collected)
+		for {
+			select {
+			case f := <-collectorChan:
+				f(results)
+			case <-quit:
+				return
+			default:
+				copy := *results
+				_ = copy.Search
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	s := &searchParams{start: 100, step: 100}
+	s.run(results)
+	close(quit)
+	<-collected
+	if results.SearchParam != "Rate" || len(results.Search) != 1 || results.SearchEnd != "0.000 cmds/sec below target rate" {
+		t.Errorf("search = %s %+v '%s'", results.SearchParam, results.Search, results.SearchEnd)
+	}
+}
@@ -0,0 +1,24 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"github.com/jkarjala/gompet/stats"
+)
+
+// Histogram counts durations in logarithmic buckets, see the stats package
+type Histogram = stats.Histogram
+
+// ServerStats is a snapshot of server-side statistics, see the stats package
+type ServerStats = stats.ServerStats
+
+// NewServerStats returns a snapshot with the service time percentiles of the histogram
+func NewServerStats(h *Histogram) *ServerStats {
+	return stats.NewServerStats(h)
+}
+
+// FetchServerStats gets the snapshot from the URL, and resets the server stats if requested
+func FetchServerStats(url string, reset bool) (*ServerStats, error) {
+	return stats.FetchServerStats(url, reset)
+}
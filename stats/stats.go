@@ -0,0 +1,130 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+// Package stats has the latency histogram and the server-side stats shared by the gompet
+// clients and the test servers. Unlike gompet it registers no flags, so servers can use it.
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const histogramMin = 1e-6     // seconds, the upper limit of the first bucket
+const histogramRatio = 1.01   // bucket width, limits the percentile error to 1%
+const histogramBuckets = 2100 // enough for 1000+ seconds
+var histogramLogRatio = math.Log(histogramRatio)
+
+// Percentiles are the percents of the latencies reported
+var Percentiles = []float64{50, 90, 95, 98, 100}
+
+// Histogram counts durations in logarithmic buckets for percentiles with constant memory.
+// This is not safe for concurrent use!
+type Histogram struct {
+	Counts []int64
+	Total  int64
+}
+
+// Add counts the duration given in seconds
+func (h *Histogram) Add(seconds float64) {
+	if h.Counts == nil {
+		h.Counts = make([]int64, histogramBuckets)
+	}
+	i := 0
+	if seconds > histogramMin {
+		i = int(math.Ceil(math.Log(seconds/histogramMin) / histogramLogRatio))
+		if i >= histogramBuckets {
+			i = histogramBuckets - 1
+		}
+	}
+	h.Counts[i]++
+	h.Total++
+}
+
+// Percentile returns the upper limit of the bucket at given percent, NaN if empty
+func (h *Histogram) Percentile(percent float64) float64 {
+	if h.Total == 0 {
+		return math.NaN()
+	}
+	rank := int64(math.Ceil(percent / 100 * float64(h.Total)))
+	var count int64
+	for i, c := range h.Counts {
+		count += c
+		if count >= rank && c > 0 {
+			return histogramMin * math.Pow(histogramRatio, float64(i))
+		}
+	}
+	return math.NaN()
+}
+
+// Reset clears the counts
+func (h *Histogram) Reset() {
+	for i := range h.Counts {
+		h.Counts[i] = 0
+	}
+	h.Total = 0
+}
+
+// ServerStats is a snapshot of server-side statistics, e.g. from gompet-httpserver /admin/stats
+type ServerStats struct {
+	Elapsed     float64          `json:"elapsed"` // seconds since server start or reset
+	Requests    int64            `json:"requests"`
+	InFlight    int64            `json:"in_flight"`
+	MaxInFlight int64            `json:"max_in_flight"`
+	Statuses    map[string]int64 `json:"statuses"`    // request counts by status code
+	Percentiles []float64        `json:"percentiles"` // percents of the service times
+	Times       []float64        `json:"times"`       // service times in seconds at the percentiles
+}
+
+// NewServerStats returns a snapshot with the service time percentiles of the histogram
+func NewServerStats(h *Histogram) *ServerStats {
+	var stats = ServerStats{Statuses: make(map[string]int64)}
+	for _, p := range Percentiles {
+		t := h.Percentile(p)
+		if math.IsNaN(t) {
+			t = 0 // no requests, JSON has no NaN
+		}
+		stats.Percentiles = append(stats.Percentiles, p)
+		stats.Times = append(stats.Times, t)
+	}
+	return &stats
+}
+
+// Time returns the service time at given percent, if included in the snapshot
+func (s *ServerStats) Time(percent float64) (float64, bool) {
+	for i, p := range s.Percentiles {
+		if p == percent && i < len(s.Times) {
+			return s.Times[i], true
+		}
+	}
+	return 0, false
+}
+
+// FetchServerStats gets the snapshot from the URL, and resets the server stats if requested
+func FetchServerStats(url string, reset bool) (*ServerStats, error) {
+	if reset {
+		if strings.Contains(url, "?") {
+			url += "&reset=1"
+		} else {
+			url += "?reset=1"
+		}
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Server stats status %d", resp.StatusCode)
+	}
+	var stats ServerStats
+	if err = json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
@@ -0,0 +1,42 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	if !math.IsNaN(h.Percentile(50)) {
+		t.Error("empty histogram has percentiles")
+	}
+	for i := 1; i <= 1000; i++ {
+		h.Add(float64(i) / 1000) // 1ms ... 1s
+	}
+	h.Add(0)
+	for _, c := range []struct{ p, want float64 }{{50, 0.5}, {90, 0.9}, {100, 1}} {
+		got := h.Percentile(c.p)
+		if got < c.want || got > c.want*histogramRatio {
+			t.Errorf("Percentile(%.0f) = %f, want %f", c.p, got, c.want)
+		}
+	}
+	h.Reset()
+	if h.Total != 0 || !math.IsNaN(h.Percentile(100)) {
+		t.Errorf("Reset left %d", h.Total)
+	}
+}
+
+func TestServerStats(t *testing.T) {
+	var h Histogram
+	h.Add(0.010)
+	stats := NewServerStats(&h)
+	if v, ok := stats.Time(100); !ok || v < 0.010 || v > 0.0102 {
+		t.Errorf("Time(100) = %f %v", v, ok)
+	}
+	if _, ok := stats.Time(99.9); ok {
+		t.Error("Time(99.9) found")
+	}
+}
@@ -0,0 +1,98 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// TLSOptions holds the TLS settings shared by the clients, usually filled from flags
+type TLSOptions struct {
+	CAFile     string // PEM file with CA certificates to trust, system pool if empty
+	CertFile   string // PEM client certificate for mutual TLS
+	KeyFile    string // PEM private key for the client certificate
+	ServerName string // SNI and verification host name, default is taken from the URL
+	MinVersion string // minimum TLS version, 1.0, 1.1, 1.2 or 1.3
+	Ciphers    string // comma-separated cipher suite names, Go defaults if empty
+	Verify     bool   // verify the server certificate, skipped by default
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSFlags registers the -tls-* flags and returns the options they set, call before flag.Parse
+func TLSFlags() *TLSOptions {
+	var opts TLSOptions
+	flag.StringVar(&opts.CAFile, "tls-ca", "", "PEM file of CA certificates for server verification")
+	flag.StringVar(&opts.CertFile, "tls-cert", "", "PEM file of client certificate for mutual TLS")
+	flag.StringVar(&opts.KeyFile, "tls-key", "", "PEM file of client certificate private key")
+	flag.StringVar(&opts.ServerName, "tls-server-name", "", "Server name for SNI and verification, default from target host")
+	flag.StringVar(&opts.MinVersion, "tls-min-version", "", "Minimum TLS version, 1.0, 1.1, 1.2 or 1.3")
+	flag.StringVar(&opts.Ciphers, "tls-ciphers", "", "Comma-separated TLS cipher suite names")
+	flag.BoolVar(&opts.Verify, "tls-verify", false, "Verify server certificate and host name")
+	return &opts
+}
+
+// NewTLSConfig creates a TLS client configuration from the options
+func NewTLSConfig(opts *TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: !opts.Verify,
+		ServerName:         opts.ServerName,
+	}
+
+	if opts.CAFile != "" {
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", opts.CAFile)
+		}
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return nil, errors.New("Both client certificate and key are required")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.MinVersion != "" {
+		version, ok := tlsVersions[opts.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("Unsupported TLS version %s, use 1.0, 1.1, 1.2 or 1.3", opts.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if opts.Ciphers != "" {
+		suites := make(map[string]uint16)
+		for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[s.Name] = s.ID
+		}
+		for _, name := range strings.Split(opts.Ciphers, ",") {
+			id, ok := suites[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("Unknown cipher suite %s", name)
+			}
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+	return config, nil
+}
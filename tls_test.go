@@ -0,0 +1,203 @@
+// This file is part of Gompet - Copyright 2019-2020 Jari Karjala - www.jpkware.com
+// SPDX-License-Identifier: GPLv3-only
+
+package gompet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCerts holds a CA and the server and client certificates it signed, written to a temp dir
+type testCerts struct {
+	dir    string
+	ca     *x509.CertPool
+	server tls.Certificate
+}
+
+// newCert creates a certificate for the name signed by the parent, self-signed if nil
+func newCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, der
+}
+
+// writePEMs writes the certificate and its key as name.crt and name.key
+func writePEMs(t *testing.T, dir, name string, der []byte, key *ecdsa.PrivateKey) {
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err = ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestCerts(t *testing.T) *testCerts {
+	dir, err := ioutil.TempDir("", "gompet-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := &testCerts{dir: dir, ca: x509.NewCertPool()}
+	ca, caKey, der := newCert(t, "Gompet CA", nil, nil)
+	certs.ca.AddCert(ca)
+	writePEMs(t, dir, "ca", der, caKey)
+	_, key, der := newCert(t, "server.gompet.test", ca, caKey)
+	certs.server = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	_, key, der = newCert(t, "client.gompet.test", ca, caKey)
+	writePEMs(t, dir, "client", der, key)
+	return certs
+}
+
+func (certs *testCerts) path(name string) string {
+	return filepath.Join(certs.dir, name)
+}
+
+// handshake connects the client to a TLS 1.2 server which asks for a client certificate,
+// returns the server's view of the connection
+func (certs *testCerts) handshake(t *testing.T, opts *TLSOptions) (tls.ConnectionState, error) {
+	config, err := NewTLSConfig(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	done := make(chan tls.ConnectionState)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			close(done)
+			return
+		}
+		server := tls.Server(conn, &tls.Config{
+			Certificates: []tls.Certificate{certs.server},
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+			ClientCAs:    certs.ca,
+			MaxVersion:   tls.VersionTLS12,
+		})
+		server.Handshake()
+		done <- server.ConnectionState()
+		server.Close()
+	}()
+	client, err := tls.Dial("tcp", lis.Addr().String(), config)
+	if err == nil {
+		client.Close()
+	}
+	return <-done, err
+}
+
+func TestTLSConfig(t *testing.T) {
+	certs := newTestCerts(t)
+	defer os.RemoveAll(certs.dir)
+
+	state, err := certs.handshake(t, &TLSOptions{ServerName: "anything"})
+	if err != nil || len(state.PeerCertificates) != 0 || state.ServerName != "anything" {
+		t.Errorf("insecure: %v, %d client certs, SNI '%s'", err, len(state.PeerCertificates), state.ServerName)
+	}
+	if _, err = certs.handshake(t, &TLSOptions{ServerName: "server.gompet.test", Verify: true}); err == nil {
+		t.Error("server verified without the CA")
+	}
+	verified := &TLSOptions{ServerName: "server.gompet.test", Verify: true, CAFile: certs.path("ca.crt"),
+		CertFile: certs.path("client.crt"), KeyFile: certs.path("client.key")}
+	state, err = certs.handshake(t, verified)
+	if err != nil || len(state.PeerCertificates) != 1 || state.ServerName != "server.gompet.test" {
+		t.Errorf("verified: %v, %d client certs, SNI '%s'", err, len(state.PeerCertificates), state.ServerName)
+	}
+	verified.ServerName = "other.gompet.test"
+	if _, err = certs.handshake(t, verified); err == nil {
+		t.Error("server verified with wrong name")
+	}
+	if _, err = certs.handshake(t, &TLSOptions{MinVersion: "1.2"}); err != nil {
+		t.Errorf("min version 1.2: %v", err)
+	}
+	if _, err = certs.handshake(t, &TLSOptions{MinVersion: "1.3"}); err == nil {
+		t.Error("min version 1.3 accepted a TLS 1.2 server")
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	certs := newTestCerts(t)
+	defer os.RemoveAll(certs.dir)
+
+	for _, tc := range []struct {
+		opts TLSOptions
+		err  string
+	}{
+		{TLSOptions{CAFile: certs.path("missing.crt")}, "no such file"},
+		{TLSOptions{CAFile: certs.path("client.key")}, "No certificates found"},
+		{TLSOptions{CertFile: certs.path("client.crt")}, "Both client certificate and key are required"},
+		{TLSOptions{KeyFile: certs.path("client.key")}, "Both client certificate and key are required"},
+		{TLSOptions{CertFile: certs.path("client.crt"), KeyFile: certs.path("ca.key")}, "private key does not match"},
+		{TLSOptions{MinVersion: "1.4"}, "Unsupported TLS version 1.4"},
+		{TLSOptions{Ciphers: "TLS_AES_128_GCM_SHA256,NO_SUCH_SUITE"}, "Unknown cipher suite NO_SUCH_SUITE"},
+	} {
+		if _, err := NewTLSConfig(&tc.opts); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%+v: error %v, want %s", tc.opts, err, tc.err)
+		}
+	}
+
+	config, err := NewTLSConfig(&TLSOptions{MinVersion: "1.2", Ciphers: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	if err != nil || config.MinVersion != tls.VersionTLS12 || len(config.CipherSuites) != 1 || !config.InsecureSkipVerify {
+		t.Errorf("config %+v, %v", config, err)
+	}
+}
+
+func TestTLSFlags(t *testing.T) {
+	opts := TLSFlags()
+	for name, value := range map[string]string{"tls-ca": "ca.crt", "tls-cert": "client.crt", "tls-key": "client.key",
+		"tls-server-name": "server.gompet.test", "tls-min-version": "1.3", "tls-ciphers": "TLS_AES_128_GCM_SHA256", "tls-verify": "true"} {
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := TLSOptions{"ca.crt", "client.crt", "client.key", "server.gompet.test", "1.3", "TLS_AES_128_GCM_SHA256", true}
+	if *opts != want {
+		t.Errorf("options %+v, want %+v", *opts, want)
+	}
+}